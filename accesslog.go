@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	accessLogJSON     = "json"
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+	accessLogLogfmt   = "logfmt"
+)
+
+type accessLogConfig struct {
+	Enabled     bool     `yaml:"enabled,omitempty"`
+	Format      string   `yaml:"format,omitempty"`
+	Sample      uint64   `yaml:"sample,omitempty"`
+	Exclude     []string `yaml:"exclude,omitempty"`
+	RedactQuery []string `yaml:"redactQuery,omitempty"`
+	IPHeader    string   `yaml:"ipHeader,omitempty"`
+}
+
+// accessLogger writes a line for every request served, in the configured format.
+type accessLogger struct {
+	cfg     accessLogConfig
+	exclude []*regexp.Regexp
+	redact  map[string]bool
+	count   atomic.Uint64
+	// mu serializes the writes of the text formats, so lines don't interleave.
+	mu     sync.Mutex
+	out    io.Writer
+	logger zerolog.Logger
+}
+
+func newAccessLogger(cfg accessLogConfig, out io.Writer) (*accessLogger, error) {
+	if cfg.Format == "" {
+		cfg.Format = accessLogJSON
+	}
+	switch cfg.Format {
+	case accessLogJSON, accessLogCommon, accessLogCombined, accessLogLogfmt:
+	default:
+		return nil, fmt.Errorf("unknown format: %s", cfg.Format)
+	}
+
+	l := &accessLogger{
+		cfg:    cfg,
+		redact: map[string]bool{},
+		out:    out,
+		logger: zerolog.New(out).With().Timestamp().Str("app", "GSS").Logger(),
+	}
+	for _, glob := range cfg.Exclude {
+		pattern, err := regexp.Compile(globToRegexp(glob))
+		if err != nil {
+			return nil, fmt.Errorf("exclude %s: %w", glob, err)
+		}
+		l.exclude = append(l.exclude, pattern)
+	}
+	for _, name := range cfg.RedactQuery {
+		l.redact[name] = true
+	}
+
+	return l, nil
+}
+
+// accessLogEntry holds the details of a request that are logged.
+type accessLogEntry struct {
+	Time      time.Time
+	Method    string
+	URI       string
+	Proto     string
+	Status    int
+	Bytes     int64
+	Duration  time.Duration
+	Encoding  string
+	Fallback  bool
+	User      string
+	UserAgent string
+	Referer   string
+	ClientIP  string
+}
+
+// accessLog logs the requests that are not excluded. With sampling, only one of every few
+// requests is logged, but server errors always are.
+func (f *fileServer) accessLog(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if f.accessLogger.excluded(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		snoop := httpsnoop.CaptureMetrics(h, w, r)
+		if snoop.Code < http.StatusInternalServerError && !f.accessLogger.sampled() {
+			return
+		}
+
+		user, _, _ := r.BasicAuth()
+		f.accessLogger.write(accessLogEntry{
+			Time:      start,
+			Method:    r.Method,
+			URI:       f.accessLogger.redactURI(r.URL),
+			Proto:     r.Proto,
+			Status:    snoop.Code,
+			Bytes:     snoop.Written,
+			Duration:  snoop.Duration,
+			Encoding:  w.Header().Get("Content-Encoding"),
+			Fallback:  getRequestInfo(r).Route == routeFallback,
+			User:      user,
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+			ClientIP:  clientIP(r, f.accessLogger.cfg.IPHeader),
+		})
+	}
+}
+
+func (l *accessLogger) excluded(r *http.Request) bool {
+	urlPath := path.Clean("/" + r.URL.Path)
+	for _, pattern := range l.exclude {
+		if pattern.MatchString(urlPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *accessLogger) sampled() bool {
+	if l.cfg.Sample <= 1 {
+		return true
+	}
+
+	return (l.count.Add(1)-1)%l.cfg.Sample == 0
+}
+
+// redactURI returns the request URI with the values of the redacted query parameters
+// replaced, or of all of them if "*" is redacted.
+func (l *accessLogger) redactURI(u *url.URL) string {
+	if len(l.redact) == 0 || u.RawQuery == "" {
+		return u.RequestURI()
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		name, _, hasValue := strings.Cut(param, "=")
+		decoded, err := url.QueryUnescape(name)
+		if err != nil {
+			decoded = name
+		}
+		if hasValue && (l.redact["*"] || l.redact[decoded]) {
+			params[i] = name + "=REDACTED"
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+
+	return redacted.RequestURI()
+}
+
+func (l *accessLogger) write(e accessLogEntry) {
+	if l.cfg.Format == accessLogJSON {
+		l.logger.Info().
+			Str("method", e.Method).
+			Str("uri", e.URI).
+			Str("protocol", e.Proto).
+			Int("status", e.Status).
+			Int64("bytes", e.Bytes).
+			Float64("duration", e.Duration.Seconds()).
+			Str("encoding", e.Encoding).
+			Bool("fallback", e.Fallback).
+			Str("userAgent", e.UserAgent).
+			Str("referer", e.Referer).
+			Str("clientIP", e.ClientIP).
+			Msg("Request served")
+		return
+	}
+
+	var line string
+	switch l.cfg.Format {
+	case accessLogCommon, accessLogCombined:
+		line = fmt.Sprintf(
+			`%s - %s [%s] "%s %s %s" %d %d`,
+			e.ClientIP,
+			orDash(e.User),
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method,
+			e.URI,
+			e.Proto,
+			e.Status,
+			e.Bytes,
+		)
+		if l.cfg.Format == accessLogCombined {
+			line += fmt.Sprintf(` %q %q`, orDash(e.Referer), orDash(e.UserAgent))
+		}
+	case accessLogLogfmt:
+		line = strings.Join([]string{
+			"time=" + e.Time.UTC().Format(time.RFC3339Nano),
+			"method=" + logfmtValue(e.Method),
+			"uri=" + logfmtValue(e.URI),
+			"protocol=" + logfmtValue(e.Proto),
+			"status=" + strconv.Itoa(e.Status),
+			"bytes=" + strconv.FormatInt(e.Bytes, 10),
+			"duration=" + strconv.FormatFloat(e.Duration.Seconds(), 'f', -1, 64),
+			"encoding=" + logfmtValue(e.Encoding),
+			"fallback=" + strconv.FormatBool(e.Fallback),
+			"userAgent=" + logfmtValue(e.UserAgent),
+			"referer=" + logfmtValue(e.Referer),
+			"clientIP=" + logfmtValue(e.ClientIP),
+		}, " ")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := io.WriteString(l.out, line+"\n")
+	if err != nil {
+		log.Error().Msgf("Error writing access log: %v", err)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// logfmtValue quotes the value if it is empty or contains characters that would break the
+// key=value pairs.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	serve := func(t *testing.T, cfg accessLogConfig, requests ...*http.Request) string {
+		t.Helper()
+
+		var out bytes.Buffer
+		cfg.Enabled = true
+		fileServer := newFileServer(&config{AccessLog: cfg}, nil).init()
+		logger, err := newAccessLogger(cfg, &out)
+		assert.NoError(t, err)
+		fileServer.accessLogger = logger
+		for _, r := range requests {
+			fileServer.Server.Handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		return out.String()
+	}
+	newRequest := func(target string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("User-Agent", "test agent")
+		r.Header.Set("Referer", "https://example.com/")
+		r.Header.Set("Accept-Encoding", "br")
+		return r
+	}
+
+	t.Run("logs requests as JSON", func(t *testing.T) {
+		t.Parallel()
+
+		out := serve(t, accessLogConfig{}, newRequest("/some/route?page=1"))
+
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(out), &entry))
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, "/some/route?page=1", entry["uri"])
+		assert.Equal(t, float64(http.StatusOK), entry["status"])
+		assert.Greater(t, entry["bytes"], float64(0))
+		assert.Contains(t, entry, "duration")
+		assert.Equal(t, "br", entry["encoding"])
+		assert.Equal(t, true, entry["fallback"])
+		assert.Equal(t, "test agent", entry["userAgent"])
+		assert.Equal(t, "https://example.com/", entry["referer"])
+		assert.Equal(t, "192.0.2.1", entry["clientIP"])
+	})
+
+	t.Run("logs requests in the Combined Log Format", func(t *testing.T) {
+		t.Parallel()
+
+		out := serve(t, accessLogConfig{Format: accessLogCombined}, newRequest("/missing.js"))
+
+		assert.Regexp(
+			t,
+			`^192\.0\.2\.1 - - \[[^\]]+\] "GET /missing\.js HTTP/1\.1" 404 0 "https://example\.com/" "test agent"\n$`,
+			out,
+		)
+	})
+
+	t.Run("logs requests as logfmt", func(t *testing.T) {
+		t.Parallel()
+
+		out := serve(t, accessLogConfig{Format: accessLogLogfmt}, newRequest("/"))
+
+		assert.Contains(t, out, ` method=GET uri=/ protocol=HTTP/1.1 status=200 `)
+		assert.Contains(
+			t,
+			out,
+			` encoding=br fallback=false userAgent="test agent" referer=https://example.com/ clientIP=192.0.2.1`,
+		)
+	})
+
+	t.Run("excludes paths", func(t *testing.T) {
+		t.Parallel()
+
+		out := serve(
+			t,
+			accessLogConfig{Format: accessLogCommon, Exclude: []string{"/healthz"}},
+			newRequest("/healthz"),
+			newRequest("/"),
+		)
+
+		assert.Equal(t, 1, strings.Count(out, "\n"))
+		assert.Contains(t, out, `"GET / HTTP/1.1"`)
+	})
+
+	t.Run("samples requests", func(t *testing.T) {
+		t.Parallel()
+
+		requests := []*http.Request{}
+		for i := 0; i < 6; i++ {
+			requests = append(requests, newRequest("/"))
+		}
+		out := serve(t, accessLogConfig{Format: accessLogCommon, Sample: 3}, requests...)
+
+		assert.Equal(t, 2, strings.Count(out, "\n"))
+	})
+
+	t.Run("redacts query parameters", func(t *testing.T) {
+		t.Parallel()
+
+		out := serve(
+			t,
+			accessLogConfig{Format: accessLogCommon, RedactQuery: []string{"token"}},
+			newRequest("/?token=secret&page=2"),
+		)
+
+		assert.Contains(t, out, `"GET /?token=REDACTED&page=2 HTTP/1.1"`)
+		assert.NotContains(t, out, "secret")
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newAccessLogger(accessLogConfig{Format: "xml"}, &bytes.Buffer{})
+
+		assert.Error(t, err)
+	})
+	t.Run("rejects invalid exclude globs", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newAccessLogger(accessLogConfig{Exclude: []string{"/{health"}}, &bytes.Buffer{})
+		assert.Error(t, err)
+
+		cfg := newConfig()
+		cfg.AccessLog = accessLogConfig{Enabled: true, Exclude: []string{"/{health"}}
+		assert.ErrorContains(t, cfg.validate(), "accessLog: exclude /{health")
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type assetsConfig struct {
+	HashPattern    string        `yaml:"hashPattern,omitempty"`
+	UnhashedMaxAge time.Duration `yaml:"unhashedMaxAge,omitempty"`
+}
+
+// defaultHashPattern matches file names with a content hash before the extension, such as
+// main.8d3db4ef.js from webpack or index-BkJ3x9aZ.js from Vite.
+var defaultHashPattern = regexp.MustCompile(`[.-]([0-9a-f]{8,}|[A-Za-z0-9_-]{8})\.`)
+
+// buildManifests are the manifests bundlers write listing the assets they have built, which
+// always have hashed names.
+var buildManifests = []string{"asset-manifest.json", "manifest.json", ".vite/manifest.json"}
+
+// isHashed reports whether the file name contains a content hash. The default pattern also
+// requires the hash to contain a digit, so words like "polyfill" are not mistaken for one.
+func isHashed(name string, pattern *regexp.Regexp) bool {
+	if pattern != nil {
+		return pattern.MatchString(name)
+	}
+	for _, match := range defaultHashPattern.FindAllStringSubmatch(name, -1) {
+		if strings.ContainsAny(match[1], "0123456789") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// manifestAssets returns the paths of the assets listed in the build manifests found in the
+// directory. Both the Create React App and the Vite manifest formats are supported.
+func manifestAssets(dir string) []string {
+	assets := []string{}
+	for _, manifest := range buildManifests {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(manifest)))
+		if err != nil {
+			continue
+		}
+
+		var craManifest struct {
+			Files       map[string]string `json:"files"`
+			Entrypoints []string          `json:"entrypoints"`
+		}
+		if json.Unmarshal(data, &craManifest) == nil && len(craManifest.Files) > 0 {
+			for _, file := range craManifest.Files {
+				assets = append(assets, file)
+			}
+			assets = append(assets, craManifest.Entrypoints...)
+			continue
+		}
+
+		var viteManifest map[string]struct {
+			File   string   `json:"file"`
+			CSS    []string `json:"css"`
+			Assets []string `json:"assets"`
+		}
+		if json.Unmarshal(data, &viteManifest) == nil {
+			for _, chunk := range viteManifest {
+				if chunk.File != "" {
+					assets = append(assets, chunk.File)
+				}
+				assets = append(assets, chunk.CSS...)
+				assets = append(assets, chunk.Assets...)
+			}
+		}
+	}
+
+	paths := []string{}
+	for _, asset := range assets {
+		// HTML files are listed in some manifests, but keep their names between builds.
+		if path.Ext(asset) == ".html" {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(asset, "/"))))
+	}
+
+	return paths
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssets(t *testing.T) {
+	t.Run("detects hashed file names", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			name   string
+			hashed bool
+		}{
+			{"main.8d3db4ef.js", true},
+			{"main.68aa49f7.css.br", true},
+			{"index-BkJ3x9aZ.js", true},
+			{"1f3a9c0e.chunk.js", false},
+			{"logo.png", false},
+			{"core-polyfill.js", false},
+			{"index.html", false},
+		}
+		for _, tt := range tests {
+			assert.Equal(t, tt.hashed, isHashed(tt.name, nil), tt.name)
+		}
+
+		assert.True(t, isHashed("logo.v2.png", regexp.MustCompile(`\.v\d+\.`)))
+	})
+
+	t.Run("reads assets from build manifests", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "asset-manifest.json"), []byte(`{
+			"files": {"main.js": "/static/js/main.js", "index.html": "/index.html"},
+			"entrypoints": ["static/css/main.css"]
+		}`), 0o600))
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, ".vite"), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, ".vite", "manifest.json"), []byte(`{
+			"src/main.ts": {"file": "assets/main.js", "css": ["assets/main.css"], "assets": ["assets/logo.svg"]}
+		}`), 0o600))
+
+		assert.ElementsMatch(t, []string{
+			filepath.Join(dir, "static", "js", "main.js"),
+			filepath.Join(dir, "static", "css", "main.css"),
+			filepath.Join(dir, "assets", "main.js"),
+			filepath.Join(dir, "assets", "main.css"),
+			filepath.Join(dir, "assets", "logo.svg"),
+		}, manifestAssets(dir))
+	})
+
+	t.Run("ignores web app manifests", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`{
+			"name": "App", "icons": [{"src": "icon.png"}]
+		}`), 0o600))
+
+		assert.Empty(t, manifestAssets(dir))
+	})
+
+	t.Run("caches files without hashed names briefly", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Assets: assetsConfig{HashPattern: "^never$", UnhashedMaxAge: time.Minute}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "public, max-age=60, must-revalidate", w.Header().Get("Cache-Control"))
+	})
+}
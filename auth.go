@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type authConfig struct {
+	HtpasswdFile string   `yaml:"htpasswdFile,omitempty"`
+	Realm        string   `yaml:"realm,omitempty"`
+	Paths        []string `yaml:"paths,omitempty"`
+}
+
+func (c *authConfig) enabled() bool {
+	return c.HtpasswdFile != ""
+}
+
+// authenticator checks the credentials of the requests matching the configured paths, or
+// of every request if none are.
+type authenticator struct {
+	cfg       authConfig
+	challenge string
+	paths     []*regexp.Regexp
+	htpasswd  *htpasswdStore
+}
+
+func newAuthenticator(cfg authConfig) (*authenticator, error) {
+	realm := cfg.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	a := &authenticator{
+		cfg:       cfg,
+		challenge: fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm),
+	}
+	for _, glob := range cfg.Paths {
+		pattern, err := regexp.Compile(globToRegexp(glob))
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", glob, err)
+		}
+		a.paths = append(a.paths, pattern)
+	}
+	htpasswd, err := newHtpasswdStore(cfg.HtpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	a.htpasswd = htpasswd
+
+	return a, nil
+}
+
+func (a *authenticator) protects(r *http.Request) bool {
+	if len(a.paths) == 0 {
+		return true
+	}
+	urlPath := path.Clean("/" + r.URL.Path)
+	for _, p := range a.paths {
+		if p.MatchString(urlPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *authenticator) close() error {
+	return a.htpasswd.close()
+}
+
+// auth requires HTTP basic authentication with the credentials of the htpasswd file.
+func (f *fileServer) auth(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := f.requestSettings(r).auth
+		if a == nil || !a.protects(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		if ok && a.htpasswd.verify(user, password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// Requests without credentials are the browser asking for them, not failed attempts.
+		if ok {
+			f.Metrics.IncAuthFailures()
+		}
+		w.Header().Set("WWW-Authenticate", a.challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+// htpasswdStore holds the users of an htpasswd file, reloading them when the file changes.
+// Only bcrypt and SHA-1 password hashes are supported.
+type htpasswdStore struct {
+	file    string
+	mu      sync.RWMutex
+	users   map[string]string
+	checked map[[sha256.Size]byte]bool
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func newHtpasswdStore(file string) (*htpasswdStore, error) {
+	s := &htpasswdStore{file: file}
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory is watched instead of the file for the same reasons as certificates.
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	err = watcher.Add(filepath.Dir(file))
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("watching htpasswd file: %w", err)
+	}
+	s.watcher = watcher
+	s.done = make(chan struct{})
+	go s.watch()
+
+	return s, nil
+}
+
+func (s *htpasswdStore) load() error {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("%s:%d: invalid entry", s.file, line)
+		}
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$2") {
+			return fmt.Errorf("%s:%d: unsupported password hash for user %s", s.file, line, user)
+		}
+		users[user] = hash
+	}
+
+	s.mu.Lock()
+	s.users = users
+	s.checked = map[[sha256.Size]byte]bool{}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *htpasswdStore) watch() {
+	defer close(s.done)
+
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) || filepath.Clean(event.Name) != filepath.Clean(s.file) {
+				continue
+			}
+			// If the new file is invalid, the previous users keep being accepted.
+			err := s.load()
+			if err != nil {
+				log.Error().Msgf("Error reloading htpasswd file: %v", err)
+				continue
+			}
+			log.Info().Msg("Htpasswd file reloaded")
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Msgf("Error watching htpasswd file: %v", err)
+		}
+	}
+}
+
+// verify reports whether the password is the one of the user. Valid credentials are
+// remembered until the file is reloaded, as bcrypt is too slow to run for every asset.
+func (s *htpasswdStore) verify(user, password string) bool {
+	key := sha256.Sum256([]byte(user + ":" + password))
+	s.mu.RLock()
+	hash, ok := s.users[user]
+	checked := s.checked[key]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if checked {
+		return true
+	}
+
+	var valid bool
+	if encoded, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password))
+		valid = subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(encoded)) == 1
+	} else {
+		valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	if valid {
+		s.mu.Lock()
+		// The file may have been reloaded meanwhile, changing the password.
+		if s.users[user] == hash {
+			s.checked[key] = true
+		}
+		s.mu.Unlock()
+	}
+
+	return valid
+}
+
+func (s *htpasswdStore) close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	err := s.watcher.Close()
+	<-s.done
+
+	return err
+}
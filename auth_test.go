@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	htpasswd := "# Users\nalice:" + string(hash) + "\n" +
+		// SHA-1 of "sha-password", as written by htpasswd -s.
+		"bob:{SHA}MNLW6wfRtawHZ/atRhQOJCUt398=\n"
+
+	newServer := func(t *testing.T, cfg authConfig) *fileServer {
+		t.Helper()
+
+		cfg.HtpasswdFile = filepath.Join(t.TempDir(), ".htpasswd")
+		assert.NoError(t, os.WriteFile(cfg.HtpasswdFile, []byte(htpasswd), 0o600))
+		fileServer := newFileServer(&config{Auth: cfg}, nil).init()
+		t.Cleanup(func() { fileServer.settings.Load().release(nil) })
+
+		return fileServer
+	}
+	request := func(fileServer *fileServer, path, user, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("requires valid credentials", func(t *testing.T) {
+		t.Parallel()
+
+		fileServer := newServer(t, authConfig{Realm: "Staging"})
+
+		w := request(fileServer, "/", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Basic realm="Staging", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+
+		assert.Equal(t, http.StatusUnauthorized, request(fileServer, "/", "alice", "wrong").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(fileServer, "/", "carol", "bcrypt-password").Code)
+		assert.Equal(t, http.StatusOK, request(fileServer, "/", "alice", "bcrypt-password").Code)
+		assert.Equal(t, http.StatusOK, request(fileServer, "/", "alice", "bcrypt-password").Code)
+		assert.Equal(t, http.StatusOK, request(fileServer, "/", "bob", "sha-password").Code)
+	})
+
+	t.Run("only protects the configured paths", func(t *testing.T) {
+		t.Parallel()
+
+		fileServer := newServer(t, authConfig{Paths: []string{"/admin/**"}})
+
+		assert.Equal(t, http.StatusOK, request(fileServer, "/", "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(fileServer, "/admin/users", "", "").Code)
+		assert.Equal(t, http.StatusOK, request(fileServer, "/admin/users", "bob", "sha-password").Code)
+	})
+
+	t.Run("reloads the file when it changes", func(t *testing.T) {
+		t.Parallel()
+
+		fileServer := newServer(t, authConfig{})
+		assert.Equal(t, http.StatusOK, request(fileServer, "/", "bob", "sha-password").Code)
+
+		// SHA-1 of "new-password".
+		err := os.WriteFile(
+			fileServer.Config.Auth.HtpasswdFile,
+			[]byte("bob:{SHA}/v2lBCUbc9MKfPPQR9JBOIo8EbM=\n"),
+			0o600,
+		)
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return request(fileServer, "/", "bob", "new-password").Code == http.StatusOK
+		}, 5*time.Second, 20*time.Millisecond)
+		assert.Equal(t, http.StatusUnauthorized, request(fileServer, "/", "bob", "sha-password").Code)
+	})
+
+	t.Run("rejects unsupported hashes", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), ".htpasswd")
+		assert.NoError(t, os.WriteFile(file, []byte("alice:$apr1$salt$hash\n"), 0o600))
+
+		_, err := newHtpasswdStore(file)
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/felixge/httpsnoop"
+	"github.com/rs/zerolog/log"
+)
+
+type compressionConfig struct {
+	Enabled   bool  `yaml:"enabled,omitempty"`
+	MinSize   int64 `yaml:"minSize,omitempty"`
+	CacheSize int64 `yaml:"cacheSize,omitempty"`
+}
+
+// dynamicEncoders are the encodings files can be compressed with on the fly.
+var dynamicEncoders = map[string]func(w io.Writer) io.WriteCloser{
+	"br": func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	},
+	"gzip": func(w io.Writer) io.WriteCloser {
+		gw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return gw
+	},
+}
+
+// compressibleTypes are the content types worth compressing besides text ones. Formats that
+// are already compressed, like images or WOFF fonts, are left out.
+var compressibleTypes = map[string]bool{
+	"application/javascript":        true,
+	"application/geo+json":          true,
+	"application/json":              true,
+	"application/manifest+json":     true,
+	"application/wasm":              true,
+	"application/xml":               true,
+	"application/vnd.ms-fontobject": true,
+	"font/otf":                      true,
+	"font/ttf":                      true,
+	"image/svg+xml":                 true,
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// canCompress reports whether the file is worth compressing on the fly.
+func (f *fileServer) canCompress(file *fileEntry) bool {
+	return f.compressor != nil && file.Size >= f.Config.Compression.MinSize && isCompressible(file.ContentType)
+}
+
+// serveDynamicallyCompressed compresses the file with the encoding and serves it. It returns
+// false if the file could not be served compressed, or compressing it doesn't make it smaller.
+func (f *fileServer) serveDynamicallyCompressed(
+	w http.ResponseWriter,
+	r *http.Request,
+	path string,
+	file *fileEntry,
+	encoding string,
+) bool {
+	newWriter, ok := dynamicEncoders[encoding]
+	if !ok || !f.canCompress(file) {
+		return false
+	}
+
+	data, err := f.compressor.get(file.ETag+encoding, func() ([]byte, error) {
+		data, err := compressFile(path, newWriter)
+		if err != nil || int64(len(data)) < file.Size {
+			return data, err
+		}
+		// An empty entry is cached, so the file is not compressed again for nothing.
+		return []byte{}, nil
+	})
+	if err != nil {
+		log.Error().Msgf("Error compressing file: %v", err)
+		return false
+	}
+	if len(data) == 0 {
+		return false
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("ETag", strings.TrimSuffix(file.ETag, `"`)+"-"+encoding+`"`)
+	snoop := httpsnoop.CaptureMetricsFn(w, func(w http.ResponseWriter) {
+		http.ServeContent(w, r, path, file.ModTime, bytes.NewReader(data))
+	})
+	if snoop.Code == http.StatusOK {
+		f.Metrics.AddCompressionSavedBytes(float64(file.Size - int64(len(data))))
+	}
+
+	return true
+}
+
+func compressFile(path string, newWriter func(w io.Writer) io.WriteCloser) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	cw := newWriter(&buf)
+	_, err = io.Copy(cw, file)
+	if err != nil {
+		return nil, err
+	}
+	err = cw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compressionCache keeps the most recently used compressed files in memory, up to a total
+// size, and makes concurrent requests for the same missing file wait for a single
+// compression.
+type compressionCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element
+	order    *list.List
+	calls    map[string]*compressionCall
+	metrics  *metrics
+}
+
+type compressionCacheEntry struct {
+	key  string
+	data []byte
+}
+
+type compressionCall struct {
+	wg   sync.WaitGroup
+	data []byte
+	err  error
+}
+
+func newCompressionCache(maxBytes int64, metrics *metrics) *compressionCache {
+	return &compressionCache{
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		calls:    map[string]*compressionCall{},
+		metrics:  metrics,
+	}
+}
+
+func (c *compressionCache) get(key string, compress func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		c.metrics.IncCompressionCacheHits()
+		return element.Value.(*compressionCacheEntry).data, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		c.metrics.IncCompressionCacheHits()
+		return call.data, call.err
+	}
+	call := &compressionCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+	c.metrics.IncCompressionCacheMisses()
+
+	call.data, call.err = compress()
+	call.wg.Done()
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if call.err == nil {
+		c.add(key, call.data)
+	}
+	c.mu.Unlock()
+
+	return call.data, call.err
+}
+
+// add stores the data evicting the least recently used entries if needed. It must be called
+// with the lock held.
+func (c *compressionCache) add(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+	for c.bytes+size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*compressionCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.bytes -= int64(len(entry.data))
+	}
+	c.entries[key] = c.order.PushFront(&compressionCacheEntry{key: key, data: data})
+	c.bytes += size
+}
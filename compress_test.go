@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompression(t *testing.T) {
+	t.Run("compresses files without a precompressed variant", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Compression: compressionConfig{Enabled: true, CacheSize: 1 << 20}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js.LICENSE.txt", nil)
+
+		r.Header.Add("Accept-Encoding", "br")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+		assert.Contains(t, w.Header().Get("ETag"), "-br")
+	})
+
+	t.Run("doesn't compress files below the minimum size", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Compression: compressionConfig{Enabled: true, MinSize: 1 << 30, CacheSize: 1 << 20}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js.LICENSE.txt", nil)
+
+		r.Header.Add("Accept-Encoding", "br")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("doesn't compress files that don't get smaller", func(t *testing.T) {
+		t.Parallel()
+
+		data := make([]byte, 4096)
+		_, err := rand.Read(data)
+		assert.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "random.txt")
+		assert.NoError(t, os.WriteFile(path, data, 0o600))
+		file := &fileEntry{Size: int64(len(data)), ContentType: "text/plain", ETag: `"random"`}
+
+		cfg := &config{Compression: compressionConfig{Enabled: true, CacheSize: 1 << 20}}
+		fileServer := newFileServer(cfg, nil).init()
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/random.txt", nil)
+
+			served := fileServer.serveDynamicallyCompressed(w, r, path, file, "gzip")
+
+			assert.False(t, served)
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+		}
+	})
+
+	t.Run("negotiates again if the file doesn't get smaller", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Compression: compressionConfig{Enabled: true, CacheSize: 1 << 20}}
+		fileServer := newFileServer(cfg, nil).init()
+		file := fileServer.index.Load().Files[filepath.Join(rootDir, "static", "main.8d3db4ef.js.LICENSE.txt")]
+		// An empty entry marks the file as not getting smaller when compressed.
+		for encoding := range dynamicEncoders {
+			_, err := fileServer.compressor.get(file.ETag+encoding, func() ([]byte, error) {
+				return []byte{}, nil
+			})
+			assert.NoError(t, err)
+		}
+
+		tests := []struct {
+			header   string
+			code     int
+			encoding string
+		}{
+			{"br", http.StatusOK, ""},
+			{"br, identity;q=0", http.StatusNotAcceptable, ""},
+			// The file has a precompressed gzip variant.
+			{"br, gzip;q=0.5, identity;q=0", http.StatusOK, "gzip"},
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js.LICENSE.txt", nil)
+			r.Header.Add("Accept-Encoding", tt.header)
+
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.code, w.Code, tt.header)
+			assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"), tt.header)
+		}
+	})
+
+	t.Run("coalesces concurrent compressions", func(t *testing.T) {
+		t.Parallel()
+
+		cache := newCompressionCache(1<<20, nil)
+		var calls atomic.Int32
+		started := make(chan struct{})
+		release := make(chan struct{})
+		compress := func() ([]byte, error) {
+			calls.Add(1)
+			close(started)
+			<-release
+			return []byte("compressed"), nil
+		}
+
+		var wg sync.WaitGroup
+		get := func() {
+			defer wg.Done()
+			data, err := cache.get("key", compress)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("compressed"), data)
+		}
+		wg.Add(1)
+		go get()
+		<-started
+		for i := 0; i < 9; i++ {
+			wg.Add(1)
+			go get()
+		}
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), calls.Load())
+		assert.Equal(t, int64(len("compressed")), cache.bytes)
+	})
+
+	t.Run("evicts the least recently used entries", func(t *testing.T) {
+		t.Parallel()
+
+		cache := newCompressionCache(10, nil)
+		compress := func(data string) func() ([]byte, error) {
+			return func() ([]byte, error) { return []byte(data), nil }
+		}
+
+		_, _ = cache.get("a", compress("aaaa"))
+		_, _ = cache.get("b", compress("bbbb"))
+		_, _ = cache.get("a", compress("aaaa"))
+		_, _ = cache.get("c", compress("cccc"))
+
+		assert.Contains(t, cache.entries, "a")
+		assert.NotContains(t, cache.entries, "b")
+		assert.Contains(t, cache.entries, "c")
+		assert.Equal(t, int64(8), cache.bytes)
+	})
+
+	t.Run("detects compressible types", func(t *testing.T) {
+		t.Parallel()
+
+		assert.True(t, isCompressible("text/plain; charset=utf-8"))
+		assert.True(t, isCompressible("application/wasm"))
+		assert.False(t, isCompressible("font/woff2"))
+		assert.False(t, isCompressible("image/png"))
+		assert.False(t, isCompressible("image/x-icon"))
+	})
+}
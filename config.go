@@ -0,0 +1,586 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// configField is an option of the config, addressed by its YAML keys joined with dots, such
+// as compression.minSize.
+type configField struct {
+	Path  string
+	Index []int
+	Type  reflect.Type
+}
+
+// configFields returns every option of the config, descending into nested structs. Options
+// that are maps or lists of structs are returned as a whole.
+func configFields() []configField {
+	return appendConfigFields(nil, reflect.TypeOf(config{}), "", nil)
+}
+
+func appendConfigFields(fields []configField, t reflect.Type, prefix string, index []int) []configField {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		fieldIndex := append(append([]int{}, index...), i)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct {
+			fields = appendConfigFields(fields, fieldType, path+".", fieldIndex)
+			continue
+		}
+		fields = append(fields, configField{Path: path, Index: fieldIndex, Type: field.Type})
+	}
+
+	return fields
+}
+
+// settable reports whether the option can be set from a single string, as environment
+// variables and flags do.
+func (f configField) settable() bool {
+	if f.Type == reflect.TypeOf(time.Duration(0)) {
+		return true
+	}
+	switch f.Type.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float64,
+		reflect.Int, reflect.Int64, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return f.Type.Elem().Kind() == reflect.String || f.Type.Elem().Kind() == reflect.Float64
+	}
+
+	return false
+}
+
+// envName returns the environment variable setting the option, such as
+// GSS_COMPRESSION_MIN_SIZE for compression.minSize.
+func (f configField) envName() string {
+	return "GSS_" + strings.ToUpper(strings.Join(f.words(), "_"))
+}
+
+// flagName returns the command line flag setting the option, such as compression-min-size
+// for compression.minSize.
+func (f configField) flagName() string {
+	return strings.Join(f.words(), "-")
+}
+
+func (f configField) words() []string {
+	var words []string
+	for _, key := range strings.Split(f.Path, ".") {
+		start := 0
+		for i, r := range key {
+			if i > 0 && unicode.IsUpper(r) {
+				words = append(words, strings.ToLower(key[start:i]))
+				start = i
+			}
+		}
+		words = append(words, strings.ToLower(key[start:]))
+	}
+
+	return words
+}
+
+// parse converts the string to the type of the option. Lists are separated by commas.
+func (f configField) parse(s string) (reflect.Value, error) {
+	v := reflect.New(f.Type).Elem()
+	var err error
+	switch {
+	case f.Type == reflect.TypeOf(time.Duration(0)):
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		v.SetInt(int64(d))
+	case f.Type.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case f.Type.Kind() == reflect.String:
+		v.SetString(s)
+	case f.Type.Kind() == reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(s, 64)
+		v.SetFloat(n)
+	case f.Type.Kind() == reflect.Int || f.Type.Kind() == reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		v.SetInt(n)
+	case f.Type.Kind() == reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 64)
+		v.SetUint(n)
+	case f.Type.Kind() == reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if f.Type.Elem().Kind() != reflect.Float64 {
+			v = reflect.ValueOf(items)
+			break
+		}
+		numbers := make([]float64, 0, len(items))
+		for _, item := range items {
+			var n float64
+			n, err = strconv.ParseFloat(item, 64)
+			if err != nil {
+				break
+			}
+			numbers = append(numbers, n)
+		}
+		v = reflect.ValueOf(numbers)
+	default:
+		err = fmt.Errorf("%s can only be set in the config file", f.Path)
+	}
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("invalid value for %s: %w", f.Path, err)
+	}
+
+	return v, nil
+}
+
+// value returns the option in the config, or an invalid value if it is inside a struct that
+// has not been set.
+func (f configField) value(c *config) reflect.Value {
+	v := reflect.ValueOf(c).Elem()
+	for _, i := range f.Index {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+
+	return v
+}
+
+// set sets the option in the config, allocating the structs containing it if needed.
+func (f configField) set(c *config, value reflect.Value) {
+	v := reflect.ValueOf(c).Elem()
+	for _, i := range f.Index {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	v.Set(value)
+}
+
+// configFlags holds the values given in the command line, to apply them after the rest of
+// the layers.
+type configFlags struct {
+	ConfigFile string
+	values     []configFlagValue
+}
+
+type configFlagValue struct {
+	field configField
+	value reflect.Value
+}
+
+// configFlag is the flag.Value of an option.
+type configFlag struct {
+	field configField
+	flags *configFlags
+}
+
+func (f configFlag) String() string {
+	return ""
+}
+
+func (f configFlag) Set(s string) error {
+	value, err := f.field.parse(s)
+	if err != nil {
+		return err
+	}
+	f.flags.values = append(f.flags.values, configFlagValue{field: f.field, value: value})
+
+	return nil
+}
+
+// IsBoolFlag allows setting boolean options without a value, as in --watch.
+func (f configFlag) IsBoolFlag() bool {
+	return f.field.Type.Kind() == reflect.Bool
+}
+
+func parseConfigFlags(args []string) (*configFlags, error) {
+	flags := &configFlags{}
+	fs := flag.NewFlagSet("gss", flag.ContinueOnError)
+	fs.StringVar(&flags.ConfigFile, "config", "", "path to the config file")
+	for _, field := range configFields() {
+		if !field.settable() {
+			continue
+		}
+		fs.Var(configFlag{field: field, flags: flags}, field.flagName(), "sets "+field.Path)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return flags, nil
+}
+
+// loadConfig returns the config resulting from applying, in order, the defaults, the config
+// file, the GSS_* environment variables and the command line flags.
+func loadConfig(args []string) *config {
+	cfg, err := readConfig(args)
+	if err != nil {
+		log.Fatal().Msgf("Error loading config: %v", err)
+	}
+
+	return cfg
+}
+
+// readConfig is like loadConfig, but returns an error instead of exiting, so the config can
+// be read again while running.
+func readConfig(args []string) (*config, error) {
+	flags, err := parseConfigFlags(args)
+	if err != nil {
+		return nil, fmt.Errorf("parsing flags: %w", err)
+	}
+
+	file, explicit := "gss.yaml", false
+	if env := os.Getenv("GSS_CONFIG"); env != "" {
+		file, explicit = env, true
+	}
+	if flags.ConfigFile != "" {
+		file, explicit = flags.ConfigFile, true
+	}
+
+	cfg := newConfig()
+	cfg.file = file
+	err = cfg.readYAML(file, explicit)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.readEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg.withFlags(flags)
+	err = cfg.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func (c *config) readEnv() error {
+	for _, field := range configFields() {
+		if !field.settable() {
+			continue
+		}
+		s, ok := os.LookupEnv(field.envName())
+		if !ok {
+			continue
+		}
+		value, err := field.parse(s)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", field.envName(), err)
+		}
+		field.set(c, value)
+		c.setSource(field.Path, sourceEnv)
+	}
+
+	return nil
+}
+
+func (c *config) withFlags(flags *configFlags) *config {
+	for _, v := range flags.values {
+		v.field.set(c, v.value)
+		c.setSource(v.field.Path, sourceFlag)
+	}
+
+	return c
+}
+
+// markFileSources records the options present in the config file, given its content decoded
+// as generic maps.
+func (c *config) markFileSources(data map[interface{}]interface{}) {
+	for _, field := range configFields() {
+		var v interface{} = data
+		var keys []string
+		found, short := true, false
+		for _, key := range strings.Split(field.Path, ".") {
+			m, ok := v.(map[interface{}]interface{})
+			if !ok {
+				short = true
+				break
+			}
+			v, found = m[key]
+			if !found {
+				break
+			}
+			keys = append(keys, key)
+		}
+		switch {
+		case short:
+			// Options like rateLimit can be set in a short form instead of a map, which only
+			// sets some of the nested options.
+			c.setSource(strings.Join(keys, "."), sourceFile)
+			if value := field.value(c); value.IsValid() && !value.IsZero() {
+				c.setSource(field.Path, sourceFile)
+			}
+		case found:
+			c.setSource(field.Path, sourceFile)
+		}
+	}
+}
+
+func (c *config) setSource(path, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[path] = source
+}
+
+func (c *config) source(path string) string {
+	if source, ok := c.sources[path]; ok {
+		return source
+	}
+
+	return sourceDefault
+}
+
+// logEffective logs the value of every option along with the layer it comes from.
+func (c *config) logEffective() {
+	redacted := c.redacted()
+	event := log.Info()
+	for _, field := range configFields() {
+		var value interface{}
+		if v := field.value(redacted); v.IsValid() {
+			value = v.Interface()
+			if d, ok := value.(time.Duration); ok {
+				value = d.String()
+			}
+		}
+		event = event.Interface(field.Path, map[string]interface{}{
+			"value":  value,
+			"source": c.source(field.Path),
+		})
+	}
+	event.Msg("Effective configuration")
+}
+
+// validate checks the options that can't be checked when decoding them, reporting every
+// problem found at once.
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(c.FilesPort), "filesPort: %d is not a valid port", c.FilesPort)
+	check(validPort(c.MetricsPort), "metricsPort: %d is not a valid port", c.MetricsPort)
+	check(
+		c.FilesPort != c.MetricsPort,
+		"metricsPort: %d is already used by filesPort",
+		c.MetricsPort,
+	)
+	check(
+		c.HTTP3.AdvertisedPort == 0 || validPort(c.HTTP3.AdvertisedPort),
+		"http3.advertisedPort: %d is not a valid port",
+		c.HTTP3.AdvertisedPort,
+	)
+	_, err := os.ReadDir(rootDir)
+	check(err == nil, "root directory is not readable: %v", err)
+
+	for _, preset := range c.HeaderPresets {
+		_, ok := headerPresets[preset]
+		check(ok, "headerPresets: unknown preset %s", preset)
+	}
+	for _, encoding := range c.Encodings {
+		_, ok := encodingExtensions[encoding]
+		check(ok, "encodings: unknown encoding %s", encoding)
+	}
+	for i, bucket := range c.MetricsBuckets {
+		check(bucket > 0, "metricsBuckets: %v is not positive", bucket)
+		check(
+			i == 0 || bucket > c.MetricsBuckets[i-1],
+			"metricsBuckets: %v is not greater than the previous bucket",
+			bucket,
+		)
+	}
+	check(c.ShutdownTimeout >= 0, "shutdownTimeout: duration can't be negative")
+	check(c.WatchDebounce >= 0, "watchDebounce: duration can't be negative")
+	check(c.Assets.UnhashedMaxAge >= 0, "assets.unhashedMaxAge: duration can't be negative")
+	check(c.Compression.MinSize >= 0, "compression.minSize: size can't be negative")
+	check(c.Compression.CacheSize >= 0, "compression.cacheSize: size can't be negative")
+
+	_, err = compileLocations(c.Locations)
+	check(err == nil, "locations: %v", err)
+	if c.Assets.HashPattern != "" {
+		_, err = regexp.Compile(c.Assets.HashPattern)
+		check(err == nil, "assets.hashPattern: %v", err)
+	}
+	if c.TLS.enabled() {
+		check(
+			c.TLS.MinVersion == "" || tlsVersions[c.TLS.MinVersion] != 0,
+			"tls.minVersion: unknown version %s",
+			c.TLS.MinVersion,
+		)
+		for _, name := range c.TLS.CipherSuites {
+			_, ok := cipherSuiteID(name)
+			check(ok, "tls.cipherSuites: unknown or insecure cipher suite %s", name)
+		}
+		files := []string{c.TLS.CertFile, c.TLS.KeyFile}
+		for _, pair := range c.TLS.Certificates {
+			files = append(files, pair.CertFile, pair.KeyFile)
+		}
+		for _, file := range files {
+			if file != "" {
+				_, err = os.Stat(file)
+				check(err == nil, "tls: %v", err)
+			}
+		}
+	}
+	check(!c.HTTP3.Enabled || c.TLS.enabled(), "http3: TLS must be enabled")
+	check(!c.H2C || !c.TLS.enabled(), "h2c: TLS must be disabled")
+	_, err = newProxyRules(c.Proxy)
+	check(err == nil, "proxy: %v", err)
+	check(
+		c.Env.Mode == "" || c.Env.Mode == envModeScript || c.Env.Mode == envModePlaceholder,
+		"env.mode: unknown mode %s",
+		c.Env.Mode,
+	)
+	if c.AccessLog.Enabled {
+		_, err = newAccessLogger(c.AccessLog, io.Discard)
+		check(err == nil, "accessLog: %v", err)
+	}
+	if c.Auth.enabled() {
+		err = (&htpasswdStore{file: c.Auth.HtpasswdFile}).load()
+		check(err == nil, "auth: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+const redactedValue = "REDACTED"
+
+// redacted returns a copy of the config with the values of the options tagged as secret,
+// such as headers sent to upstreams, replaced.
+func (c *config) redacted() *config {
+	redacted := redactValue(reflect.ValueOf(c).Elem()).Interface().(config)
+
+	return &redacted
+}
+
+func redactValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(redactValue(v.Elem()))
+		return p
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return s
+	case reflect.Struct:
+		s := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" {
+				s.Field(i).Set(redactSecret(v.Field(i)))
+				continue
+			}
+			s.Field(i).Set(redactValue(v.Field(i)))
+		}
+		return s
+	}
+
+	return v
+}
+
+// redactSecret replaces a string, or the values of a map of strings, keeping the keys as
+// they are usually header names.
+func redactSecret(v reflect.Value) reflect.Value {
+	switch {
+	case v.Kind() == reflect.String && v.Len() > 0:
+		return reflect.ValueOf(redactedValue).Convert(v.Type())
+	case v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.String && !v.IsNil():
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			m.SetMapIndex(key, reflect.ValueOf(redactedValue).Convert(v.Type().Elem()))
+		}
+		return m
+	}
+
+	return v
+}
+
+// runConfigCommand runs the config subcommands, which load the config as the server would
+// and either validate or print it. It returns the code the process should exit with.
+func runConfigCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "validate" && args[0] != "print" {
+		log.Error().Msg("Usage: gss config validate|print [flags]")
+		return 2
+	}
+
+	cfg := loadConfig(args[1:])
+	if args[0] == "validate" {
+		log.Info().Msg("Config is valid")
+		return 0
+	}
+
+	data, err := yaml.Marshal(cfg.redacted())
+	if err != nil {
+		log.Error().Msgf("Error marshalling config: %v", err)
+		return 1
+	}
+	_, err = out.Write(data)
+	if err != nil {
+		log.Error().Msgf("Error printing config: %v", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("names environment variables and flags after the options", func(t *testing.T) {
+		t.Parallel()
+
+		names := map[string][2]string{}
+		for _, field := range configFields() {
+			names[field.Path] = [2]string{field.envName(), field.flagName()}
+		}
+
+		assert.Equal(t, [2]string{"GSS_FILES_PORT", "files-port"}, names["filesPort"])
+		assert.Equal(t, [2]string{"GSS_COMPRESSION_MIN_SIZE", "compression-min-size"}, names["compression.minSize"])
+		assert.Equal(t, [2]string{"GSS_RATE_LIMIT_IP_HEADER", "rate-limit-ip-header"}, names["rateLimit.ipHeader"])
+		assert.Equal(t, [2]string{"GSS_HTTP3_ENABLED", "http3-enabled"}, names["http3.enabled"])
+	})
+
+	t.Run("applies the layers in order", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		data := "filesPort: 3000\nmetricsPort: 3001\nshutdownTimeout: 10s\nrateLimit: 10\n"
+		assert.NoError(t, os.WriteFile(file, []byte(data), 0o600))
+		t.Setenv("GSS_CONFIG", file)
+		t.Setenv("GSS_METRICS_PORT", "4001")
+		t.Setenv("GSS_SHUTDOWN_TIMEOUT", "20s")
+		t.Setenv("GSS_ENCODINGS", "gzip, br")
+
+		cfg := loadConfig([]string{"--shutdown-timeout", "30s", "--compression-enabled", "--metrics-buckets", "0.1,1"})
+
+		assert.Equal(t, 3000, cfg.FilesPort)
+		assert.Equal(t, 4001, cfg.MetricsPort)
+		assert.Equal(t, 30*time.Second, cfg.ShutdownTimeout)
+		assert.Equal(t, uint64(10), cfg.RateLimit.Tokens)
+		assert.Equal(t, []string{"gzip", "br"}, cfg.Encodings)
+		assert.True(t, cfg.Compression.Enabled)
+		assert.Equal(t, []float64{0.1, 1}, cfg.MetricsBuckets)
+		assert.Equal(t, time.Second, cfg.WatchDebounce)
+
+		assert.Equal(t, sourceFile, cfg.source("filesPort"))
+		assert.Equal(t, sourceEnv, cfg.source("metricsPort"))
+		assert.Equal(t, sourceFile, cfg.source("rateLimit.tokens"))
+		assert.Equal(t, sourceDefault, cfg.source("rateLimit.interval"))
+		assert.Equal(t, sourceDefault, cfg.source("rateLimit.burst"))
+		assert.Equal(t, sourceFlag, cfg.source("shutdownTimeout"))
+		assert.Equal(t, sourceFile, cfg.source("rateLimit.tokens"))
+		assert.Equal(t, sourceDefault, cfg.source("watchDebounce"))
+	})
+
+	t.Run("prefers the config file given as a flag", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "env.yaml"), []byte("filesPort: 3000\n"), 0o600))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "flag.yaml"), []byte("filesPort: 4000\n"), 0o600))
+		t.Setenv("GSS_CONFIG", filepath.Join(dir, "env.yaml"))
+
+		cfg := loadConfig([]string{"--config", filepath.Join(dir, "flag.yaml")})
+
+		assert.Equal(t, 4000, cfg.FilesPort)
+	})
+
+	t.Run("sets options inside unset structs", func(t *testing.T) {
+		t.Parallel()
+
+		flags, err := parseConfigFlags([]string{"--rate-limit-tokens", "5"})
+		assert.NoError(t, err)
+
+		cfg := newConfig().withFlags(flags)
+
+		assert.Equal(t, uint64(5), cfg.RateLimit.Tokens)
+	})
+
+	t.Run("rejects invalid flag values", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := parseConfigFlags([]string{"--files-port", "http"})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestConfigValidation(t *testing.T) {
+	t.Run("accepts the defaults", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, newConfig().validate())
+	})
+
+	t.Run("reports every invalid option", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := newConfig()
+		cfg.FilesPort = 70000
+		cfg.MetricsEnabled = true
+		cfg.MetricsPort = 70000
+		cfg.Encodings = []string{"deflate"}
+		cfg.MetricsBuckets = []float64{0.5, 0.1}
+		cfg.HTTP3.Enabled = true
+
+		err := cfg.validate()
+
+		assert.EqualError(
+			t,
+			err,
+			"filesPort: 70000 is not a valid port\n"+
+				"metricsPort: 70000 is not a valid port\n"+
+				"metricsPort: 70000 is already used by filesPort\n"+
+				"encodings: unknown encoding deflate\n"+
+				"metricsBuckets: 0.1 is not greater than the previous bucket\n"+
+				"http3: TLS must be enabled",
+		)
+	})
+
+	t.Run("redacts secrets", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := newConfig()
+		cfg.Proxy = []proxyConfig{{
+			Prefix:          "/api",
+			Upstream:        "http://api",
+			RequestHeaders:  map[string]string{"X-Api-Key": "secret"},
+			ResponseHeaders: map[string]string{"Server": ""},
+		}}
+
+		redacted := cfg.redacted()
+
+		assert.Equal(t, map[string]string{"X-Api-Key": redactedValue}, redacted.Proxy[0].RequestHeaders)
+		assert.Equal(t, map[string]string{"Server": ""}, redacted.Proxy[0].ResponseHeaders)
+		assert.Equal(t, "secret", cfg.Proxy[0].RequestHeaders["X-Api-Key"])
+		assert.Equal(t, cfg.FilesPort, redacted.FilesPort)
+	})
+
+	t.Run("prints the effective config", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "gss.yaml")
+		data := "proxy:\n  - prefix: /api\n    upstream: http://api\n    requestHeaders:\n      X-Api-Key: secret\n"
+		assert.NoError(t, os.WriteFile(file, []byte(data), 0o600))
+		t.Setenv("GSS_CONFIG", file)
+		var out bytes.Buffer
+
+		code := runConfigCommand([]string{"print", "--files-port", "3000"}, &out)
+
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out.String(), "filesPort: 3000\n")
+		assert.Contains(t, out.String(), "X-Api-Key: REDACTED\n")
+		assert.NotContains(t, out.String(), "secret")
+	})
+
+	t.Run("documents every option in the schema", func(t *testing.T) {
+		t.Parallel()
+
+		data, err := os.ReadFile("gss.schema.json")
+		assert.NoError(t, err)
+		var schema map[string]interface{}
+		assert.NoError(t, json.Unmarshal(data, &schema))
+
+		for _, field := range configFields() {
+			node := schema
+			for _, key := range strings.Split(field.Path, ".") {
+				// Options with a short form list the object form among the alternatives.
+				if alternatives, ok := node["oneOf"].([]interface{}); ok {
+					node = alternatives[len(alternatives)-1].(map[string]interface{})
+				}
+				properties, _ := node["properties"].(map[string]interface{})
+				node, _ = properties[key].(map[string]interface{})
+				if !assert.NotNil(t, node, field.Path) {
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type cspConfig struct {
+	Directives map[string][]string `yaml:"directives,omitempty"`
+	Nonce      bool                `yaml:"nonce,omitempty"`
+	ReportOnly bool                `yaml:"reportOnly,omitempty"`
+}
+
+func (c *cspConfig) enabled() bool {
+	return len(c.Directives) > 0
+}
+
+// contentSecurityPolicy builds the Content-Security-Policy header from the configured
+// directives, allowing the inline blocks of the index through their hashes and, if enabled,
+// a nonce generated for every request.
+type contentSecurityPolicy struct {
+	header     string
+	directives map[string][]string
+	nonce      bool
+}
+
+func newContentSecurityPolicy(cfg cspConfig) *contentSecurityPolicy {
+	csp := &contentSecurityPolicy{
+		header:     "Content-Security-Policy",
+		directives: map[string][]string{},
+		nonce:      cfg.Nonce,
+	}
+	if cfg.ReportOnly {
+		csp.header = "Content-Security-Policy-Report-Only"
+	}
+	for name, sources := range cfg.Directives {
+		csp.directives[strings.ToLower(name)] = sources
+	}
+
+	return csp
+}
+
+// value returns the policy allowing the hashes and the nonce, if any. Sources are only added
+// to directives restricting scripts and styles, directly or through default-src, as adding
+// them to a missing one would block everything else.
+func (c *contentSecurityPolicy) value(hashes inlineHashes, nonce string) string {
+	directives := make(map[string][]string, len(c.directives)+2)
+	for name, sources := range c.directives {
+		directives[name] = sources
+	}
+	extend := func(name string, hashes []string) {
+		sources := append([]string{}, hashes...)
+		if nonce != "" {
+			sources = append(sources, "'nonce-"+nonce+"'")
+		}
+		if len(sources) == 0 {
+			return
+		}
+		base, ok := c.directives[name]
+		if !ok {
+			base, ok = c.directives["default-src"]
+			if !ok {
+				return
+			}
+		}
+		directives[name] = append(append([]string{}, base...), sources...)
+	}
+	extend("script-src", hashes.Scripts)
+	extend("style-src", hashes.Styles)
+
+	names := make([]string, 0, len(directives))
+	for name := range directives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	policy := make([]string, 0, len(names))
+	for _, name := range names {
+		policy = append(policy, strings.TrimSpace(name+" "+strings.Join(directives[name], " ")))
+	}
+
+	return strings.Join(policy, "; ")
+}
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// inlineHashes holds the CSP hash sources of the inline scripts and styles of a document.
+type inlineHashes struct {
+	Scripts []string
+	Styles  []string
+}
+
+var (
+	inlineScript = regexp.MustCompile(`(?is)<script(\s[^>]*)?>(.*?)</script\s*>`)
+	inlineStyle  = regexp.MustCompile(`(?is)<style(\s[^>]*)?>(.*?)</style\s*>`)
+	srcAttribute = regexp.MustCompile(`(?i)\ssrc\s*=`)
+	nonceTarget  = regexp.MustCompile(`(?i)<(script|style)(\s|>)`)
+)
+
+func parseInlineHashes(data []byte) inlineHashes {
+	hashes := inlineHashes{}
+	for _, match := range inlineScript.FindAllSubmatch(data, -1) {
+		// Scripts loading a file are allowed by the source of the file.
+		if srcAttribute.Match(match[1]) {
+			continue
+		}
+		hashes.Scripts = appendHash(hashes.Scripts, match[2])
+	}
+	for _, match := range inlineStyle.FindAllSubmatch(data, -1) {
+		hashes.Styles = appendHash(hashes.Styles, match[2])
+	}
+
+	return hashes
+}
+
+func appendHash(hashes []string, content []byte) []string {
+	sum := sha256.Sum256(content)
+	hash := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+	for _, h := range hashes {
+		if h == hash {
+			return hashes
+		}
+	}
+
+	return append(hashes, hash)
+}
+
+// addNonce adds the nonce attribute to every script and style element of the document.
+func addNonce(data []byte, nonce string) []byte {
+	return nonceTarget.ReplaceAll(data, []byte(`<$1 nonce="`+nonce+`"$2`))
+}
+
+// indexHashes returns the hashes of the inline blocks of the index as it is served.
+func (f *fileServer) indexHashes() inlineHashes {
+	path := filepath.Join(rootDir, "index.html")
+	file, ok := f.index.Load().Files[path]
+	if !ok {
+		return inlineHashes{}
+	}
+	if f.Config.Env.Prefix != "" {
+		rendered, err := f.renderer.get(path, file)
+		if err == nil {
+			return rendered.hashes
+		}
+	}
+
+	return file.InlineHashes
+}
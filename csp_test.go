@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSP(t *testing.T) {
+	hash := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+	}
+
+	t.Run("builds the header from the directives", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{CSP: cspConfig{Directives: map[string][]string{
+			"default-src": {"'self'"},
+			"img-src":     {"'self'", "data:"},
+		}}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "default-src 'self'; img-src 'self' data:", w.Header().Get("Content-Security-Policy"))
+	})
+
+	t.Run("allows the inline scripts of the index", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{
+			Env: envConfig{Prefix: "GSS_CSP_TEST_"},
+			CSP: cspConfig{
+				Directives: map[string][]string{"default-src": {"'self'"}},
+				ReportOnly: true,
+			},
+		}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+		assert.Equal(
+			t,
+			"default-src 'self'; script-src 'self' "+hash("window.__ENV__={};"),
+			w.Header().Get("Content-Security-Policy-Report-Only"),
+		)
+	})
+
+	t.Run("adds a nonce to every request", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{CSP: cspConfig{
+			Directives: map[string][]string{"default-src": {"'self'"}, "style-src": {"'self'"}},
+			Nonce:      true,
+		}}
+		fileServer := newFileServer(cfg, nil).init()
+		nonces := []string{}
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("ETag"))
+			match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(w.Header().Get("Content-Security-Policy"))
+			if assert.Len(t, match, 2) {
+				nonce := match[1]
+				assert.Equal(
+					t,
+					"default-src 'self'; script-src 'self' 'nonce-"+nonce+"'; style-src 'self' 'nonce-"+nonce+"'",
+					w.Header().Get("Content-Security-Policy"),
+				)
+				assert.Contains(t, w.Body.String(), `<script nonce="`+nonce+`" defer="defer"`)
+				nonces = append(nonces, nonce)
+			}
+		}
+		assert.Len(t, nonces, 2)
+		assert.NotEqual(t, nonces[0], nonces[1])
+	})
+
+	t.Run("doesn't add sources to missing directives", func(t *testing.T) {
+		t.Parallel()
+
+		csp := newContentSecurityPolicy(cspConfig{Directives: map[string][]string{"img-src": {"'self'"}}})
+
+		assert.Equal(t, "img-src 'self'", csp.value(inlineHashes{Scripts: []string{hash("a")}}, "nonce"))
+	})
+
+	t.Run("hashes inline scripts and styles", func(t *testing.T) {
+		t.Parallel()
+
+		hashes := parseInlineHashes([]byte(
+			`<script src="main.js"></script><script>alert(1)</script><SCRIPT type="module">alert(1)</SCRIPT>` +
+				`<style media="print">body{color:red}</style>`,
+		))
+
+		assert.Equal(t, []string{hash("alert(1)")}, hashes.Scripts)
+		assert.Equal(t, []string{hash("body{color:red}")}, hashes.Styles)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime/debug"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+type debugConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Token   string `yaml:"token,omitempty"   secret:"true"`
+}
+
+// registerDebugHandlers mounts the pprof, expvar and build info endpoints under /debug/.
+// Both pprof and expvar also register themselves in the default mux, which is not served.
+func registerDebugHandlers(mux *http.ServeMux, cfg debugConfig) {
+	debugMux := http.NewServeMux()
+	debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+	debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debugMux.Handle("/debug/vars", expvar.Handler())
+	debugMux.HandleFunc("/debug/buildinfo", buildInfo)
+
+	if cfg.Token == "" {
+		log.Warn().Msg("Debug endpoints are enabled without a token")
+		mux.Handle("/debug/", debugMux)
+		return
+	}
+	mux.Handle("/debug/", requireBearerToken(cfg.Token, debugMux))
+}
+
+// requireBearerToken rejects the requests without the token in the Authorization header.
+func requireBearerToken(token string, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="debug"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+}
+
+// buildInfo writes the Go version, module versions and VCS details the binary was built with.
+func buildInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	deps := map[string]string{}
+	for _, d := range info.Deps {
+		deps[d.Path] = d.Version
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"goVersion":    info.GoVersion,
+		"path":         info.Path,
+		"version":      info.Main.Version,
+		"settings":     settings,
+		"dependencies": deps,
+	})
+	if err != nil {
+		log.Error().Msgf("Error writing build info: %v", err)
+	}
+}
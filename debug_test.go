@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebug(t *testing.T) {
+	request := func(h http.Handler, path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("is disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		internal := newInternalServer(cfg, nil, newFileServer(cfg, nil).init())
+
+		assert.Equal(t, http.StatusNotFound, request(internal.Server.Handler, "/debug/vars", "").Code)
+	})
+
+	t.Run("serves the endpoints on the internal server only", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Debug: debugConfig{Enabled: true}}
+		fileServer := newFileServer(cfg, nil).init()
+		internal := newInternalServer(cfg, nil, fileServer)
+
+		vars := request(internal.Server.Handler, "/debug/vars", "")
+		assert.Equal(t, http.StatusOK, vars.Code)
+		assert.Contains(t, vars.Body.String(), "memstats")
+		assert.Equal(t, http.StatusOK, request(internal.Server.Handler, "/debug/pprof/", "").Code)
+		assert.NotContains(t, request(fileServer.Server.Handler, "/debug/vars", "").Body.String(), "memstats")
+	})
+
+	t.Run("requires the token if set", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Debug: debugConfig{Enabled: true, Token: "secret"}}
+		internal := newInternalServer(cfg, nil, newFileServer(cfg, nil).init())
+
+		w := request(internal.Server.Handler, "/debug/pprof/", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Bearer realm="debug"`, w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, http.StatusUnauthorized, request(internal.Server.Handler, "/debug/pprof/", "wrong").Code)
+		assert.Equal(t, http.StatusOK, request(internal.Server.Handler, "/debug/pprof/", "secret").Code)
+		assert.Equal(t, http.StatusOK, request(internal.Server.Handler, "/healthz", "").Code)
+	})
+
+	t.Run("serves build info", func(t *testing.T) {
+		t.Parallel()
+
+		w := request(http.HandlerFunc(buildInfo), "/debug/buildinfo", "")
+
+		var info map[string]interface{}
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		assert.NotEmpty(t, info["goVersion"])
+	})
+}
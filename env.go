@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	envModeScript      = "script"
+	envModePlaceholder = "placeholder"
+)
+
+type envConfig struct {
+	Prefix string `yaml:"prefix,omitempty"`
+	Mode   string `yaml:"mode,omitempty"`
+}
+
+// indexRenderer renders environment variables into the index, and keeps the result along
+// with its compressed variants until the index changes. Without a prefix, the index is kept
+// as is, so it can be served with a nonce.
+type indexRenderer struct {
+	mode     string
+	vars     map[string]string
+	mu       sync.Mutex
+	rendered *renderedFile
+}
+
+type renderedFile struct {
+	// source is the ETag of the file the content was rendered from.
+	source  string
+	modTime time.Time
+	// variants maps the encodings the content is available in, including identity, to the
+	// encoded content.
+	variants map[string]renderedVariant
+	hashes   inlineHashes
+}
+
+type renderedVariant struct {
+	data []byte
+	etag string
+}
+
+func newIndexRenderer(cfg envConfig) (*indexRenderer, error) {
+	if cfg.Prefix == "" {
+		return &indexRenderer{}, nil
+	}
+	mode := cfg.Mode
+	if mode == "" {
+		mode = envModeScript
+	}
+	if mode != envModeScript && mode != envModePlaceholder {
+		return nil, fmt.Errorf("unknown mode: %s", mode)
+	}
+
+	vars := map[string]string{}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, cfg.Prefix) {
+			vars[name] = value
+		}
+	}
+
+	return &indexRenderer{mode: mode, vars: vars}, nil
+}
+
+// get returns the rendered content of the file, rendering it again if the file has changed
+// since the last time.
+func (i *indexRenderer) get(path string, file *fileEntry) (*renderedFile, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.rendered != nil && i.rendered.source == file.ETag {
+		return i.rendered, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = i.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	etag := hashContent(data)
+	rendered := &renderedFile{
+		source:  file.ETag,
+		modTime: file.ModTime,
+		hashes:  parseInlineHashes(data),
+		variants: map[string]renderedVariant{
+			identityEncoding: {data: data, etag: etag},
+		},
+	}
+	for encoding := range dynamicEncoders {
+		encoded, err := encode(data, encoding)
+		if err != nil {
+			return nil, err
+		}
+		rendered.variants[encoding] = renderedVariant{
+			data: encoded,
+			etag: strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`,
+		}
+	}
+	i.rendered = rendered
+
+	return rendered, nil
+}
+
+var headTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// render injects the variables as a script defining window.__ENV__ at the start of the head,
+// so it runs before any other script, or replaces the %NAME% placeholders with their values.
+func (i *indexRenderer) render(data []byte) ([]byte, error) {
+	switch i.mode {
+	case "":
+		return data, nil
+	case envModePlaceholder:
+		for name, value := range i.vars {
+			data = bytes.ReplaceAll(data, []byte("%"+name+"%"), []byte(value))
+		}
+		return data, nil
+	}
+
+	// The JSON encoder escapes <, > and &, so values can't close the script element.
+	vars, err := json.Marshal(i.vars)
+	if err != nil {
+		return nil, err
+	}
+	script := []byte("<script>window.__ENV__=" + string(vars) + ";</script>")
+	loc := headTag.FindIndex(data)
+	if loc == nil {
+		return append(script, data...), nil
+	}
+
+	rendered := make([]byte, 0, len(data)+len(script))
+	rendered = append(rendered, data[:loc[1]]...)
+	rendered = append(rendered, script...)
+	rendered = append(rendered, data[loc[1]:]...)
+
+	return rendered, nil
+}
+
+func (r *renderedFile) availableEncodings() map[string]bool {
+	available := map[string]bool{}
+	for encoding := range r.variants {
+		if encoding != identityEncoding {
+			available[encoding] = true
+		}
+	}
+
+	return available
+}
+
+// serve writes the variant for the encoding. With a nonce, the content is rewritten and
+// compressed for the request, and served without validators as it is never the same.
+func (r *renderedFile) serve(w http.ResponseWriter, req *http.Request, name, contentType, encoding, nonce string) {
+	variant := r.variants[encoding]
+	modTime := r.modTime
+	if nonce != "" {
+		data, err := encode(addNonce(r.variants[identityEncoding].data, nonce), encoding)
+		if err != nil {
+			log.Error().Msgf("Error compressing file: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		variant = renderedVariant{data: data}
+		modTime = time.Time{}
+	}
+
+	if encoding != identityEncoding {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Type", contentType)
+	if variant.etag != "" {
+		w.Header().Set("ETag", variant.etag)
+	}
+	http.ServeContent(w, req, name, modTime, bytes.NewReader(variant.data))
+}
+
+// encode compresses the data with one of the dynamic encoders, or returns it as is for
+// identity.
+func encode(data []byte, encoding string) ([]byte, error) {
+	newWriter, ok := dynamicEncoders[encoding]
+	if !ok {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	cw := newWriter(&buf)
+	_, err := cw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = cw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+	t.Setenv("GSS_PUBLIC_API_URL", "https://api.example.com/?a=1&b=</script>")
+	t.Setenv("GSS_PRIVATE_TOKEN", "secret")
+
+	cfg := &config{Env: envConfig{Prefix: "GSS_PUBLIC_"}}
+	fileServer := newFileServer(cfg, nil).init()
+
+	t.Run("injects the variables into the index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(
+			t,
+			w.Body.String(),
+			`<head><script>window.__ENV__={"GSS_PUBLIC_API_URL":`+
+				`"https://api.example.com/?a=1\u0026b=\u003c/script\u003e"};</script><meta`,
+		)
+		assert.NotContains(t, w.Body.String(), "secret")
+	})
+
+	t.Run("serves compressed variants of the rendered index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/some/route", nil)
+
+		r.Header.Add("Accept-Encoding", "br")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Contains(t, w.Header().Get("ETag"), "-br")
+		body, err := io.ReadAll(brotli.NewReader(w.Body))
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), "window.__ENV__")
+	})
+
+	t.Run("supports conditional requests", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		etag := w.Header().Get("ETag")
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Add("If-None-Match", etag)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("replaces placeholders", func(t *testing.T) {
+		renderer, err := newIndexRenderer(envConfig{Prefix: "GSS_PUBLIC_", Mode: envModePlaceholder})
+		assert.NoError(t, err)
+
+		rendered, err := renderer.render([]byte(`<base href="%GSS_PUBLIC_API_URL%"><p>%GSS_PRIVATE_TOKEN%</p>`))
+
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			`<base href="https://api.example.com/?a=1&b=</script>"><p>%GSS_PRIVATE_TOKEN%</p>`,
+			string(rendered),
+		)
+	})
+
+	t.Run("injects the script when there is no head", func(t *testing.T) {
+		renderer, err := newIndexRenderer(envConfig{Prefix: "GSS_PUBLIC_NONE_"})
+		assert.NoError(t, err)
+
+		rendered, err := renderer.render([]byte(`<body></body>`))
+
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(rendered, []byte(`<script>window.__ENV__={};</script>`)))
+	})
+
+	t.Run("rejects unknown modes", func(t *testing.T) {
+		_, err := newIndexRenderer(envConfig{Prefix: "GSS_PUBLIC_", Mode: "inline"})
+
+		assert.Error(t, err)
+	})
+}
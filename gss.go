@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/felixge/httpsnoop"
@@ -17,26 +25,101 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// rootDir is the directory containing the files to serve.
+const rootDir = "dist"
+
+const (
+	exitOK = iota
+	exitServerError
+	exitShutdownError
+)
+
 func main() {
 	setUpLogger()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:], os.Stdout))
+	}
 
 	var metrics *metrics
-	cfg := newConfig().withYAML()
+	cfg := loadConfig(os.Args[1:])
+	cfg.logEffective()
+	servers := map[string]server{}
 	if cfg.MetricsEnabled {
-		metrics = registerMetrics()
-		internalServer := newInternalServer(cfg, metrics)
+		metrics = registerMetrics(cfg.MetricsBuckets)
+	}
+	fileServer := newFileServer(cfg, metrics).init()
+	servers["file server"] = fileServer
+	servers["internal server"] = newInternalServer(cfg, metrics, fileServer)
+	reloader, err := newConfigReloader(os.Args[1:], fileServer)
+	if err != nil {
+		log.Fatal().Msgf("Error watching config file: %v", err)
+	}
+	servers["config reloader"] = reloader
+	if fileServer.HTTP3 != nil {
+		servers["HTTP/3 server"] = fileServer.HTTP3
+	}
+
+	os.Exit(supervise(servers, cfg.ShutdownTimeout))
+}
+
+type server interface {
+	run() error
+	shutdown(ctx context.Context) error
+}
+
+// supervise runs the servers until one of them fails or a termination signal is received,
+// and then shuts all of them down, giving in-flight requests up to the timeout to complete.
+// It returns the code the process should exit with.
+func supervise(servers map[string]server, timeout time.Duration) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+	for name, s := range servers {
+		name, s := name, s
 		go func() {
-			err := internalServer.run()
-			if err != nil {
-				log.Fatal().Msgf("Error starting internal server: %v", err)
+			err := s.run()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s: %v", name, err)
 			}
 		}()
 	}
 
-	err := newFileServer(cfg, metrics).init().run()
-	if err != nil {
-		log.Fatal().Msgf("Error starting file server: %v", err)
+	code := exitOK
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("Received termination signal, shutting down")
+	case err := <-errs:
+		log.Error().Msgf("Error running %v, shutting down", err)
+		code = exitServerError
+	}
+	// Restore the default signal behavior, so a second signal terminates the process immediately.
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, s := range servers {
+		name, s := name, s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.shutdown(shutdownCtx)
+			if err != nil {
+				log.Error().Msgf("Error shutting down %s: %v", name, err)
+				mu.Lock()
+				if code == exitOK {
+					code = exitShutdownError
+				}
+				mu.Unlock()
+			}
+		}()
 	}
+	wg.Wait()
+
+	return code
 }
 
 func setUpLogger() {
@@ -48,45 +131,129 @@ func setUpLogger() {
 }
 
 type config struct {
-	FilesPort      int  `yaml:"filesPort,omitempty"`
-	MetricsPort    int  `yaml:"metricsPort,omitempty"`
-	MetricsEnabled bool `yaml:"metrics,omitempty"`
+	FilesPort       int               `yaml:"filesPort,omitempty"`
+	MetricsPort     int               `yaml:"metricsPort,omitempty"`
+	MetricsEnabled  bool              `yaml:"metrics,omitempty"`
+	MetricsBuckets  []float64         `yaml:"metricsBuckets,omitempty"`
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	HeaderPresets   []string          `yaml:"headerPresets,omitempty"`
+	RateLimit       *rateLimitConfig  `yaml:"rateLimit,omitempty"`
+	ShutdownTimeout time.Duration     `yaml:"shutdownTimeout,omitempty"`
+	Watch           bool              `yaml:"watch,omitempty"`
+	WatchDebounce   time.Duration     `yaml:"watchDebounce,omitempty"`
+	Compression     compressionConfig `yaml:"compression,omitempty"`
+	Encodings       []string          `yaml:"encodings,omitempty"`
+	Locations       []locationConfig  `yaml:"locations,omitempty"`
+	Assets          assetsConfig      `yaml:"assets,omitempty"`
+	MIMETypes       map[string]string `yaml:"mimeTypes,omitempty"`
+	TLS             tlsConfig         `yaml:"tls,omitempty"`
+	H2C             bool              `yaml:"h2c,omitempty"`
+	HTTP3           http3Config       `yaml:"http3,omitempty"`
+	Proxy           []proxyConfig     `yaml:"proxy,omitempty"`
+	Env             envConfig         `yaml:"env,omitempty"`
+	CSP             cspConfig         `yaml:"csp,omitempty"`
+	Auth            authConfig        `yaml:"auth,omitempty"`
+	AccessLog       accessLogConfig   `yaml:"accessLog,omitempty"`
+	Debug           debugConfig       `yaml:"debug,omitempty"`
+	// sources maps the options that are not set by default to the layer setting them.
+	sources map[string]string
+	// file is the config file the config was read from, which may not exist.
+	file string
+}
+
+// headerPresets are named sets of response headers that can be enabled in the config
+// instead of listing every header manually.
+var headerPresets = map[string]map[string]string{
+	"security": {
+		"X-Frame-Options":        "SAMEORIGIN",
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+		"Permissions-Policy":     "camera=(), geolocation=(), microphone=()",
+	},
+	"hsts": {
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains; preload",
+	},
 }
 
 func newConfig() *config {
 	return &config{
 		// Default values
-		FilesPort:      8080,
-		MetricsPort:    8081,
-		MetricsEnabled: false,
+		FilesPort:       8080,
+		MetricsPort:     8081,
+		MetricsEnabled:  false,
+		ShutdownTimeout: 5 * time.Second,
+		Watch:           false,
+		WatchDebounce:   time.Second,
+		Compression: compressionConfig{
+			Enabled:   false,
+			MinSize:   1024,
+			CacheSize: 64 << 20,
+		},
+		Assets: assetsConfig{
+			UnhashedMaxAge: 5 * time.Minute,
+		},
 	}
 }
 
-func (c *config) withYAML() *config {
-	file := "gss.yaml"
+func (c *config) readYAML(file string, explicit bool) error {
 	_, err := os.Stat(file)
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) && !explicit {
 		// If no file is found we assume config via YAML is not used
-		return c
+		return nil
 	}
 
 	data, err := os.ReadFile(file)
 	if err != nil {
-		log.Fatal().Msgf("Error reading config file: %v", err)
+		return fmt.Errorf("reading config file: %w", err)
 	}
 
-	err = yaml.Unmarshal([]byte(data), &c)
+	// Unknown keys are rejected, so typos don't go unnoticed.
+	err = yaml.UnmarshalStrict([]byte(data), &c)
+	if err != nil {
+		return fmt.Errorf("unmarshalling file data: %w", err)
+	}
+	var keys map[interface{}]interface{}
+	err = yaml.Unmarshal([]byte(data), &keys)
 	if err != nil {
-		log.Fatal().Msgf("Error unmarshalling file data: %v", err)
+		return fmt.Errorf("unmarshalling file data: %w", err)
+	}
+	c.markFileSources(keys)
+
+	return nil
+}
+
+// responseHeaders returns the headers to add to every response. Headers set explicitly
+// take precedence over the ones coming from presets.
+func (c *config) responseHeaders() http.Header {
+	headers := http.Header{}
+	for _, preset := range c.HeaderPresets {
+		for k, v := range headerPresets[preset] {
+			headers.Set(k, v)
+		}
+	}
+	for k, v := range c.Headers {
+		headers.Set(k, v)
 	}
 
-	return c
+	return headers
 }
 
 type fileServer struct {
-	Config  *config
-	Metrics *metrics
-	Server  *http.Server
+	Config       *config
+	Metrics      *metrics
+	Server       *http.Server
+	HTTP3        *http3Server
+	settings     atomic.Pointer[handlerSettings]
+	index        atomic.Pointer[fileIndex]
+	watcher      *fileWatcher
+	compressor   *compressionCache
+	certs        *certificateStore
+	proxies      []proxyRule
+	renderer     *indexRenderer
+	accessLogger *accessLogger
+	shuttingDown atomic.Bool
+	// stopped is closed once the server has shut down.
+	stopped chan struct{}
 }
 
 func newFileServer(cfg *config, metrics *metrics) *fileServer {
@@ -97,26 +264,148 @@ func newFileServer(cfg *config, metrics *metrics) *fileServer {
 			Addr:         ":" + strconv.Itoa(cfg.FilesPort),
 			WriteTimeout: 10 * time.Second,
 		},
+		stopped: make(chan struct{}),
 	}
 }
 
 func (f *fileServer) init() *fileServer {
+	settings, err := newHandlerSettings(f.Config, nil)
+	if err != nil {
+		log.Fatal().Msgf("Error configuring handlers: %v", err)
+	}
+	f.settings.Store(settings)
+	opts := indexOptions{ContentTypes: normalizeContentTypes(f.Config.MIMETypes)}
+	if f.Config.Assets.HashPattern != "" {
+		pattern, err := regexp.Compile(f.Config.Assets.HashPattern)
+		if err != nil {
+			log.Fatal().Msgf("Error compiling hash pattern: %v", err)
+		}
+		opts.HashPattern = pattern
+	}
+	index, err := buildFileIndex(rootDir, opts)
+	if err != nil {
+		log.Fatal().Msgf("Error indexing files: %v", err)
+	}
+	f.index.Store(index)
+	f.Metrics.SetFileIndex(index)
+	if f.Config.Compression.Enabled {
+		f.compressor = newCompressionCache(f.Config.Compression.CacheSize, f.Metrics)
+	}
+	// The renderer is also used to add a nonce to the index on every request, which can be
+	// enabled by reloading the config.
+	renderer, err := newIndexRenderer(f.Config.Env)
+	if err != nil {
+		log.Fatal().Msgf("Error configuring environment variables: %v", err)
+	}
+	f.renderer = renderer
+	if f.Config.Watch {
+		watcher, err := newFileWatcher(rootDir, opts, f.Config.WatchDebounce, &f.index, f.Metrics)
+		if err != nil {
+			log.Fatal().Msgf("Error watching files: %v", err)
+		}
+		f.watcher = watcher
+	}
+
+	if f.Config.TLS.enabled() {
+		tlsConfig, certs, err := newTLSConfig(&f.Config.TLS)
+		if err != nil {
+			log.Fatal().Msgf("Error configuring TLS: %v", err)
+		}
+		f.Server.TLSConfig = tlsConfig
+		f.certs = certs
+	}
+
+	var handler http.Handler = f.setHeaders(f.serveSPA())
+	if len(f.Config.Proxy) > 0 {
+		proxies, err := newProxyRules(f.Config.Proxy)
+		if err != nil {
+			log.Fatal().Msgf("Error configuring proxy: %v", err)
+		}
+		f.proxies = proxies
+		handler = f.proxy(handler)
+	}
+	// Authentication and rate limiting are always in place, as they can be enabled by
+	// reloading the config.
+	handler = f.rateLimit(f.auth(handler))
 	if f.Config.MetricsEnabled {
-		f.Server.Handler = metricsMiddleware(f.Metrics)(f.setHeaders((f.serveSPA())))
-	} else {
-		f.Server.Handler = f.setHeaders((f.serveSPA()))
+		handler = metricsMiddleware(f.Metrics)(handler)
+	}
+	if f.Config.AccessLog.Enabled {
+		// Access logs go to the standard output, apart from the application logs.
+		accessLogger, err := newAccessLogger(f.Config.AccessLog, os.Stdout)
+		if err != nil {
+			log.Fatal().Msgf("Error configuring access logs: %v", err)
+		}
+		f.accessLogger = accessLogger
+		handler = f.accessLog(handler)
 	}
+	handler = trackRequest(handler)
+	switch {
+	case f.Config.HTTP3.Enabled:
+		if f.Server.TLSConfig == nil {
+			log.Fatal().Msg("Error configuring HTTP/3: TLS must be enabled")
+		}
+		f.HTTP3 = newHTTP3Server(f.Config, f.Server, handler)
+		handler = f.HTTP3.advertise(handler)
+	case f.Config.H2C:
+		if f.Server.TLSConfig != nil {
+			log.Fatal().Msg("Error configuring h2c: TLS must be disabled")
+		}
+		handler, err = withH2C(f.Server, handler)
+		if err != nil {
+			log.Fatal().Msgf("Error configuring h2c: %v", err)
+		}
+	}
+	f.Server.Handler = handler
 
 	return f
 }
 
 func (f *fileServer) run() error {
+	if f.Server.TLSConfig != nil {
+		// Certificates are provided by the TLS config.
+		return f.Server.ListenAndServeTLS("", "")
+	}
+
 	return f.Server.ListenAndServe()
 }
 
+func (f *fileServer) shutdown(ctx context.Context) error {
+	f.shuttingDown.Store(true)
+	defer close(f.stopped)
+
+	if f.watcher != nil {
+		err := f.watcher.close()
+		if err != nil {
+			log.Error().Msgf("Error closing file watcher: %v", err)
+		}
+	}
+	if f.certs != nil {
+		err := f.certs.close()
+		if err != nil {
+			log.Error().Msgf("Error closing certificate watcher: %v", err)
+		}
+	}
+	err := f.Server.Shutdown(ctx)
+	f.settings.Load().release(nil)
+
+	return err
+}
+
 func (f *fileServer) setHeaders(h http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Vary", "Accept-Encoding")
+		settings := f.requestSettings(r)
+		for k := range settings.headers {
+			w.Header().Set(k, settings.headers.Get(k))
+		}
+		if csp := settings.csp; csp != nil {
+			var nonce string
+			if csp.nonce {
+				nonce = newNonce()
+				getRequestInfo(r).Nonce = nonce
+			}
+			w.Header().Set(csp.header, csp.value(f.indexHashes(), nonce))
+		}
 
 		h.ServeHTTP(w, r)
 	}
@@ -124,108 +413,155 @@ func (f *fileServer) setHeaders(h http.Handler) http.HandlerFunc {
 
 func (f *fileServer) serveSPA() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		dir := "dist"
-		requestedFile := filepath.Join(dir, filepath.Clean(r.URL.Path))
+		settings := f.requestSettings(r)
+		urlPath := path.Clean("/" + r.URL.Path)
 
 		// Send the index if the root path is requested.
-		if filepath.Clean(r.URL.Path) == "/" {
-			requestedFile = filepath.Join(dir, "index.html")
+		if urlPath == "/" {
+			urlPath = "/index.html"
 		}
-
-		// Send a 404 if a file with extension is not found, and the index if it has no extension,
-		// as it will likely be a SPA route.
-		_, err := os.Stat(requestedFile)
-		if os.IsNotExist(err) {
-			if filepath.Ext(requestedFile) != "" {
+		requestedFile := filepath.Join(rootDir, filepath.FromSlash(urlPath))
+		rules := matchLocations(settings.locations, urlPath)
+
+		// When a file is not found, send the index if the path has no extension, as it will likely be
+		// a SPA route, and a 404 otherwise. Locations can enable or disable the fallback explicitly.
+		index := f.index.Load()
+		file, ok := index.Files[requestedFile]
+		if !ok {
+			fallback := path.Ext(urlPath) == ""
+			if rules.Fallback != nil {
+				fallback = *rules.Fallback
+			}
+			if !fallback {
+				getRequestInfo(r).Route = routeNotFound
 				w.WriteHeader(http.StatusNotFound)
 				return
 			}
-			requestedFile = filepath.Join(dir, "index.html")
+			requestedFile = filepath.Join(rootDir, "index.html")
+			rules = matchLocations(settings.locations, "/index.html")
+			file, ok = index.Files[requestedFile]
+			if !ok {
+				getRequestInfo(r).Route = routeNotFound
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			getRequestInfo(r).Route = routeFallback
+		} else if requestedFile == filepath.Join(rootDir, "index.html") {
+			getRequestInfo(r).Route = routeIndex
+		} else {
+			getRequestInfo(r).Route = routeAsset
 		}
 
-		serveFile := func(mimeType string) {
-			availableFiles := getFiles(dir)
-			acceptedEncodings := r.Header.Get("Accept-Encoding")
-			brotli := "br"
-			brotliExt := ".br"
-			gzip := "gzip"
-			gzipExt := ".gz"
-			serveCompressed := func(encoding, extension string) {
-				w.Header().Set("Content-Encoding", encoding)
-				w.Header().Set("Content-Type", mimeType)
-				http.ServeFile(w, r, requestedFile+extension)
+		serveFile := func() {
+			// The index with environment variables rendered into it is served from memory,
+			// as its precompressed variants on disk are outdated.
+			var rendered *renderedFile
+			nonce := getRequestInfo(r).Nonce
+			if (f.Config.Env.Prefix != "" || nonce != "") && requestedFile == filepath.Join(rootDir, "index.html") {
+				var err error
+				rendered, err = f.renderer.get(requestedFile, file)
+				if err != nil {
+					log.Error().Msgf("Error rendering index: %v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+			}
+
+			available := f.availableEncodings(file)
+			if rendered != nil {
+				available = rendered.availableEncodings()
+			}
+			encoding, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"), settings.encodings, available)
+			// The response only varies by the accepted encodings if there is more than one
+			// representation to choose from, or if none of them is acceptable.
+			if len(available) > 0 || !ok {
+				w.Header().Add("Vary", "Accept-Encoding")
+			}
+			if !ok {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			if rendered != nil {
+				rendered.serve(w, r, requestedFile, file.ContentType, encoding, nonce)
+				return
 			}
-			if strings.Contains(acceptedEncodings, brotli) {
-				for _, f := range availableFiles {
-					if f == requestedFile+brotliExt {
-						serveCompressed(brotli, brotliExt)
-						return
-					}
+
+			// If there is no precompressed file, compress it on the fly.
+			if _, ok := file.Encodings[encoding]; !ok && encoding != identityEncoding {
+				if f.serveDynamicallyCompressed(w, r, requestedFile, file, encoding) {
+					return
+				}
+				// If the file can't be compressed, the encoding is negotiated again without
+				// the dynamic ones, as identity may not be acceptable.
+				encoding, ok = negotiateEncoding(
+					r.Header.Get("Accept-Encoding"),
+					settings.encodings,
+					precompressedEncodings(file),
+				)
+				if !ok {
+					w.WriteHeader(http.StatusNotAcceptable)
+					return
 				}
 			}
-			if strings.Contains(acceptedEncodings, gzip) {
-				for _, f := range availableFiles {
-					if f == requestedFile+gzipExt {
-						serveCompressed(gzip, gzipExt)
-						return
-					}
+			if variant, ok := file.Encodings[encoding]; ok {
+				w.Header().Set("Content-Encoding", encoding)
+				w.Header().Set("Content-Type", file.ContentType)
+				if variantFile, ok := index.Files[variant]; ok {
+					w.Header().Set("ETag", variantFile.ETag)
 				}
+				http.ServeFile(w, r, variant)
+				return
 			}
-			// If the request does not accept compressed files, or the directory does not contain compressed files,
+			// If the request does not accept compressed files, or the file can't be compressed,
 			// serve the file as is.
+			if file.ContentType != "" {
+				w.Header().Set("Content-Type", file.ContentType)
+			}
+			w.Header().Set("ETag", file.ETag)
 			http.ServeFile(w, r, requestedFile)
 		}
 
-		switch filepath.Ext(requestedFile) {
-		case ".html":
-			w.Header().Set("Cache-Control", "no-cache")
-			serveFile("text/html")
-		case ".css":
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			serveFile("text/css")
-		case ".js":
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			serveFile("application/javascript")
-		case ".svg":
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			serveFile("image/svg+xml")
-		default:
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			http.ServeFile(w, r, requestedFile)
-		}
-	}
-}
-
-func getFiles(dir string) []string {
-	files := []string{}
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
+		// Files with hashed names are cached forever, as a new version will have a different
+		// name, while the rest are cached briefly and revalidated.
+		if rules.CacheControl == "" {
+			if file.Hashed {
+				rules.CacheControl = "public, max-age=31536000, immutable"
+			} else {
+				rules.CacheControl = fmt.Sprintf(
+					"public, max-age=%d, must-revalidate",
+					int(settings.config.Assets.UnhashedMaxAge.Seconds()),
+				)
+			}
 		}
-		files = append(files, path)
-		return nil
-	})
-	if err != nil {
-		log.Error().Msgf("Error getting files to serve: %v", err)
+		rules.apply(w)
+		serveFile()
 	}
-
-	return files
 }
 
+// internalServer serves the probes of the file server and, if enabled, the metrics and the
+// debug endpoints, on a port that is not meant to be public.
 type internalServer struct {
-	Server *http.Server
+	Server     *http.Server
+	fileServer *fileServer
 }
 
-func newInternalServer(cfg *config, metrics *metrics) *internalServer {
-	http.HandleFunc("/metrics", metrics.Default().ServeHTTP)
+func newInternalServer(cfg *config, metrics *metrics, fileServer *fileServer) *internalServer {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz(fileServer.ready))
+	if metrics != nil {
+		mux.Handle("/metrics", metrics.Default())
+	}
+	if cfg.Debug.Enabled {
+		registerDebugHandlers(mux, cfg.Debug)
+	}
 
 	return &internalServer{
 		Server: &http.Server{
-			Addr: ":" + strconv.Itoa(cfg.MetricsPort),
+			Addr:    ":" + strconv.Itoa(cfg.MetricsPort),
+			Handler: mux,
 		},
+		fileServer: fileServer,
 	}
 }
 
@@ -233,14 +569,47 @@ func (i *internalServer) run() error {
 	return i.Server.ListenAndServe()
 }
 
+// shutdown waits for the file server to shut down first, so probes keep reporting it as not
+// ready while it finishes the requests in flight.
+func (i *internalServer) shutdown(ctx context.Context) error {
+	select {
+	case <-i.fileServer.stopped:
+	case <-ctx.Done():
+	}
+
+	return i.Server.Shutdown(ctx)
+}
+
 type metrics struct {
 	requestsReceived *prometheus.CounterVec
 	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
 	bytesWritten     prometheus.Counter
+	encodings        *prometheus.CounterVec
+	conditionals     *prometheus.CounterVec
+	rateLimited      prometheus.Counter
+	authFailures     prometheus.Counter
+	reloadErrors     prometheus.Counter
+	indexFiles       prometheus.Gauge
+	indexBytes       prometheus.Gauge
+	indexBuildTime   prometheus.Gauge
+	cacheHits        prometheus.Counter
+	cacheMisses      prometheus.Counter
+	bytesSaved       prometheus.Counter
 }
 
-func registerMetrics() *metrics {
-	const labelCode = "code"
+// registerMetrics registers the metrics, observing request durations in the buckets, or the
+// default ones if none are given.
+func registerMetrics(buckets []float64) *metrics {
+	const (
+		labelCode     = "code"
+		labelProtocol = "protocol"
+		labelMethod   = "method"
+		labelRoute    = "route"
+	)
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
 
 	reqReceived := promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -248,15 +617,23 @@ func registerMetrics() *metrics {
 			Name:      "requests_total",
 			Help:      "Total number of requests received.",
 		},
-		[]string{labelCode},
+		[]string{labelCode, labelProtocol, labelMethod, labelRoute},
 	)
 	reqDuration := promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "http",
 			Name:      "request_duration_seconds",
 			Help:      "Duration of a request in seconds.",
+			Buckets:   buckets,
+		},
+		[]string{labelCode, labelProtocol, labelMethod, labelRoute},
+	)
+	reqInFlight := promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of requests being served.",
 		},
-		[]string{labelCode},
 	)
 	bytesWritten := promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -265,11 +642,107 @@ func registerMetrics() *metrics {
 			Help:      "Total number of bytes written.",
 		},
 	)
+	encodings := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "http",
+			Name:      "response_encodings_total",
+			Help:      "Total number of successful responses by content encoding.",
+		},
+		[]string{"encoding"},
+	)
+	conditionals := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "http",
+			Name:      "conditional_requests_total",
+			Help:      "Total number of conditional requests by outcome.",
+		},
+		[]string{"outcome"},
+	)
+
+	rateLimited := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "http",
+			Name:      "requests_rate_limited_total",
+			Help:      "Total number of requests rejected by the rate limiter.",
+		},
+	)
+
+	authFailures := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "http",
+			Name:      "auth_failures_total",
+			Help:      "Total number of requests rejected for invalid credentials.",
+		},
+	)
+
+	reloadErrors := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "gss",
+			Name:      "config_reload_errors_total",
+			Help:      "Total number of config reloads that failed, keeping the previous config.",
+		},
+	)
+
+	indexFiles := promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "gss",
+			Name:      "file_index_files",
+			Help:      "Number of files in the file index.",
+		},
+	)
+	indexBytes := promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "gss",
+			Name:      "file_index_bytes",
+			Help:      "Total size in bytes of the files in the file index.",
+		},
+	)
+	indexBuildTime := promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "gss",
+			Name:      "file_index_build_duration_seconds",
+			Help:      "Duration of the last file index build in seconds.",
+		},
+	)
+
+	cacheHits := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "gss",
+			Name:      "compression_cache_hits_total",
+			Help:      "Total number of dynamically compressed files served from the cache.",
+		},
+	)
+	cacheMisses := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "gss",
+			Name:      "compression_cache_misses_total",
+			Help:      "Total number of dynamically compressed files not found in the cache.",
+		},
+	)
+	bytesSaved := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "gss",
+			Name:      "compression_saved_bytes_total",
+			Help:      "Total number of bytes saved by compressing files dynamically.",
+		},
+	)
 
 	return &metrics{
 		requestsReceived: reqReceived,
 		requestDuration:  reqDuration,
+		requestsInFlight: reqInFlight,
 		bytesWritten:     bytesWritten,
+		encodings:        encodings,
+		conditionals:     conditionals,
+		rateLimited:      rateLimited,
+		authFailures:     authFailures,
+		reloadErrors:     reloadErrors,
+		indexFiles:       indexFiles,
+		indexBytes:       indexBytes,
+		indexBuildTime:   indexBuildTime,
+		cacheHits:        cacheHits,
+		cacheMisses:      cacheMisses,
+		bytesSaved:       bytesSaved,
 	}
 }
 
@@ -277,25 +750,192 @@ func (m *metrics) Default() http.Handler {
 	return promhttp.Handler()
 }
 
-func (m *metrics) IncRequests(code int) {
-	m.requestsReceived.WithLabelValues(strconv.Itoa(code)).Inc()
+func (m *metrics) IncRequests(code int, protocol, method, route string) {
+	m.requestsReceived.WithLabelValues(strconv.Itoa(code), protocol, method, route).Inc()
 }
 
-func (m *metrics) ObsDuration(code int, duration float64) {
-	m.requestDuration.WithLabelValues(strconv.Itoa(code)).Observe(duration)
+func (m *metrics) ObsDuration(code int, protocol, method, route string, duration float64) {
+	m.requestDuration.WithLabelValues(strconv.Itoa(code), protocol, method, route).Observe(duration)
 }
 
 func (m *metrics) AddBytes(bytes float64) {
 	m.bytesWritten.Add(bytes)
 }
 
+func (m *metrics) IncEncodings(encoding string) {
+	m.encodings.WithLabelValues(encoding).Inc()
+}
+
+func (m *metrics) IncConditionals(outcome string) {
+	m.conditionals.WithLabelValues(outcome).Inc()
+}
+
+// IncRateLimited is safe to call when metrics are disabled.
+func (m *metrics) IncRateLimited() {
+	if m == nil {
+		return
+	}
+	m.rateLimited.Inc()
+}
+
+// IncAuthFailures is safe to call when metrics are disabled.
+func (m *metrics) IncAuthFailures() {
+	if m == nil {
+		return
+	}
+	m.authFailures.Inc()
+}
+
+// IncConfigReloadErrors is safe to call when metrics are disabled.
+func (m *metrics) IncConfigReloadErrors() {
+	if m == nil {
+		return
+	}
+	m.reloadErrors.Inc()
+}
+
+// SetFileIndex is safe to call when metrics are disabled.
+func (m *metrics) SetFileIndex(index *fileIndex) {
+	if m == nil {
+		return
+	}
+	m.indexFiles.Set(float64(len(index.Files)))
+	m.indexBytes.Set(float64(index.Bytes))
+	m.indexBuildTime.Set(index.BuildTime.Seconds())
+}
+
+// IncCompressionCacheHits is safe to call when metrics are disabled.
+func (m *metrics) IncCompressionCacheHits() {
+	if m == nil {
+		return
+	}
+	m.cacheHits.Inc()
+}
+
+// IncCompressionCacheMisses is safe to call when metrics are disabled.
+func (m *metrics) IncCompressionCacheMisses() {
+	if m == nil {
+		return
+	}
+	m.cacheMisses.Inc()
+}
+
+// AddCompressionSavedBytes is safe to call when metrics are disabled.
+func (m *metrics) AddCompressionSavedBytes(bytes float64) {
+	if m == nil {
+		return
+	}
+	m.bytesSaved.Add(bytes)
+}
+
 func metricsMiddleware(metrics *metrics) func(h http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			metrics.requestsInFlight.Inc()
+			defer metrics.requestsInFlight.Dec()
+
 			snoop := httpsnoop.CaptureMetrics(h, w, r)
-			metrics.IncRequests(snoop.Code)
-			metrics.ObsDuration(snoop.Code, snoop.Duration.Seconds())
+			method := methodLabel(r.Method)
+			route := getRequestInfo(r).Route
+			metrics.IncRequests(snoop.Code, r.Proto, method, route)
+			metrics.ObsDuration(snoop.Code, r.Proto, method, route, snoop.Duration.Seconds())
 			metrics.AddBytes(float64(snoop.Written))
+			if snoop.Code >= http.StatusOK && snoop.Code < http.StatusMultipleChoices {
+				metrics.IncEncodings(encodingLabel(w.Header().Get("Content-Encoding")))
+			}
+			if outcome, ok := conditionalOutcome(r, snoop.Code); ok {
+				metrics.IncConditionals(outcome)
+			}
 		})
 	}
 }
+
+// Routes classify requests by how they were served, as reported in the metrics.
+const (
+	routeIndex    = "index"
+	routeAsset    = "asset"
+	routeFallback = "fallback"
+	routeProxy    = "proxy"
+	routeNotFound = "404"
+	// routeRejected is the route of requests rejected before being routed, such as by the
+	// rate limiter.
+	routeRejected = "rejected"
+)
+
+// methodLabel returns the method, or OTHER for unknown methods, so clients can't create an
+// unbounded number of series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+
+	return "OTHER"
+}
+
+// encodingLabel returns the content encoding of a response, or other for encodings gss
+// doesn't serve itself, such as the ones of proxied responses.
+func encodingLabel(encoding string) string {
+	if encoding == "" {
+		return identityEncoding
+	}
+	if _, ok := encodingExtensions[encoding]; ok {
+		return encoding
+	}
+
+	return "other"
+}
+
+// conditionalOutcome returns whether the representation was not modified, was modified, or
+// didn't match the preconditions, if the request was conditional.
+func conditionalOutcome(r *http.Request, code int) (string, bool) {
+	conditional := false
+	for _, header := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		if r.Header.Get(header) != "" {
+			conditional = true
+			break
+		}
+	}
+	switch {
+	case !conditional:
+		return "", false
+	case code == http.StatusNotModified:
+		return "not_modified", true
+	case code == http.StatusPreconditionFailed:
+		return "precondition_failed", true
+	}
+
+	return "modified", true
+}
+
+// requestInfo is filled by the handlers with details about how a request was served, so the
+// middlewares wrapping them can report it.
+type requestInfo struct {
+	Route string
+	// Nonce is the CSP nonce generated for the request, if any.
+	Nonce string
+	// Settings are the handler settings the request is served with.
+	Settings *handlerSettings
+}
+
+type requestInfoKey struct{}
+
+// trackRequest adds a requestInfo to the context of every request.
+func trackRequest(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{Route: routeRejected}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	}
+}
+
+// getRequestInfo returns the requestInfo of the request. If the request is not tracked, the
+// returned value is not reported anywhere.
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+
+	return info
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGSS(t *testing.T) {
-	metrics := registerMetrics()
+	metrics := registerMetrics([]float64{0.01, 0.1, 1})
 
 	t.Run("redirects index correctly", func(t *testing.T) {
 		t.Parallel()
@@ -147,6 +150,39 @@ func TestGSS(t *testing.T) {
 		})
 	})
 
+	t.Run("serves zstd files succesfully", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		fileServer := newFileServer(cfg, metrics).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js", nil)
+
+		r.Header.Add("Accept-Encoding", "zstd, gzip")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+		assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+	})
+
+	t.Run("serves the configured preferred encoding", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Encodings: []string{"gzip", "br"}}
+		fileServer := newFileServer(cfg, metrics).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		r.Header.Add("Accept-Encoding", "br, gzip, zstd")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	})
+
 	t.Run("serves unexisting files without extension", func(t *testing.T) {
 		t.Parallel()
 
@@ -202,6 +238,49 @@ func TestGSS(t *testing.T) {
 			assert.Equal(t, w.Header().Get("Cache-Control"), "no-cache")
 		})
 
+		t.Run("HTML files should be revalidated with their ETag", func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			etag := w.Header().Get("ETag")
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+
+			r.Header.Set("If-None-Match", etag)
+
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			assert.NotEmpty(t, etag)
+			assert.Equal(t, http.StatusNotModified, w.Code)
+		})
+
+		t.Run("compressed files should have their own ETag", func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			identity := w.Header().Get("ETag")
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+
+			r.Header.Set("Accept-Encoding", "br")
+			r.Header.Set("If-None-Match", identity)
+
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NotEqual(t, identity, w.Header().Get("ETag"))
+		})
+
 		t.Run("other files should have Cache-Control: public, max-age=31536000, immutable", func(t *testing.T) {
 			t.Parallel()
 
@@ -223,4 +302,99 @@ func TestGSS(t *testing.T) {
 			assert.Equal(t, w.Header().Get("Cache-Control"), "public, max-age=31536000, immutable")
 		})
 	})
+
+	t.Run("sets configured and preset headers", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{
+			Headers: map[string]string{
+				"referrer-policy": "no-referrer",
+				"X-Custom":        "value",
+			},
+			HeaderPresets: []string{"security", "hsts"},
+		}
+		fileServer := newFileServer(cfg, metrics).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "value", w.Header().Get("X-Custom"))
+		assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+		assert.NotEmpty(t, w.Header().Get("Strict-Transport-Security"))
+	})
+
+	t.Run("reports request metrics", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{MetricsEnabled: true}
+		fileServer := newFileServer(cfg, metrics).init()
+		serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			for k := range header {
+				r.Header.Set(k, header.Get(k))
+			}
+			fileServer.Server.Handler.ServeHTTP(w, r)
+			return w
+		}
+		serve("/some/route", nil)
+		serve("/missing.png", nil)
+		asset := serve("/static/main.68aa49f7.css", nil)
+		serve("/static/main.68aa49f7.css", http.Header{"If-None-Match": {asset.Header().Get("ETag")}})
+
+		w := httptest.NewRecorder()
+		metrics.Default().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		body := w.Body.String()
+		assert.Contains(t, body, `http_requests_total{code="200",method="GET",protocol="HTTP/1.1",route="fallback"}`)
+		assert.Contains(t, body, `http_requests_total{code="404",method="GET",protocol="HTTP/1.1",route="404"}`)
+		assert.Contains(t, body, `http_requests_total{code="304",method="GET",protocol="HTTP/1.1",route="asset"}`)
+		assert.Contains(t, body, `http_conditional_requests_total{outcome="not_modified"}`)
+		assert.Contains(t, body, `http_response_encodings_total{encoding="identity"}`)
+		assert.Contains(t, body, `le="0.01"`)
+		assert.Contains(t, body, "http_requests_in_flight")
+		assert.Equal(t, "OTHER", methodLabel("PROPFIND"))
+		assert.Equal(t, "other", encodingLabel("compress"))
+	})
+}
+
+type fakeServer struct {
+	err     error
+	stopped chan struct{}
+}
+
+func newFakeServer(err error) *fakeServer {
+	return &fakeServer{err: err, stopped: make(chan struct{})}
+}
+
+func (s *fakeServer) run() error {
+	if s.err != nil {
+		return s.err
+	}
+	<-s.stopped
+
+	return http.ErrServerClosed
+}
+
+func (s *fakeServer) shutdown(ctx context.Context) error {
+	close(s.stopped)
+
+	return nil
+}
+
+func TestSupervise(t *testing.T) {
+	t.Run("shuts down every server when one fails", func(t *testing.T) {
+		healthy := newFakeServer(nil)
+		failing := newFakeServer(errors.New("address already in use"))
+
+		code := supervise(map[string]server{"healthy": healthy, "failing": failing}, time.Second)
+
+		assert.Equal(t, exitServerError, code)
+		_, running := <-healthy.stopped
+		assert.False(t, running)
+	})
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// healthz reports that the process is alive, regardless of whether it can serve files.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the file server can serve requests, and the reason why not
+// otherwise.
+func readyz(ready func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		err := ready()
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintf(w, "not ready: %v\n", err)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+// ready returns an error if the file server is shutting down, is rebuilding the file index,
+// or can't read the index from the root directory.
+func (f *fileServer) ready() error {
+	if f.shuttingDown.Load() {
+		return errors.New("shutting down")
+	}
+	if f.watcher != nil && f.watcher.rebuilding.Load() {
+		return errors.New("rebuilding file index")
+	}
+
+	info, err := os.Stat(rootDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", rootDir)
+	}
+	index, err := os.Open(filepath.Join(rootDir, "index.html"))
+	if err != nil {
+		return err
+	}
+
+	return index.Close()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	probe := func(internal *internalServer, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		internal.Server.Handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("reports liveness and readiness", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		internal := newInternalServer(cfg, nil, newFileServer(cfg, nil).init())
+
+		assert.Equal(t, http.StatusOK, probe(internal, "/healthz").Code)
+		assert.Equal(t, http.StatusOK, probe(internal, "/readyz").Code)
+		assert.Equal(t, http.StatusNotFound, probe(internal, "/metrics").Code)
+	})
+
+	t.Run("is not ready while shutting down", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		fileServer := newFileServer(cfg, nil).init()
+		internal := newInternalServer(cfg, nil, fileServer)
+		fileServer.shuttingDown.Store(true)
+
+		w := probe(internal, "/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "shutting down")
+		assert.Equal(t, http.StatusOK, probe(internal, "/healthz").Code)
+	})
+
+	t.Run("is not ready while rebuilding the file index", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Watch: true}
+		fileServer := newFileServer(cfg, nil).init()
+		t.Cleanup(func() { fileServer.watcher.close() })
+		internal := newInternalServer(cfg, nil, fileServer)
+		fileServer.watcher.rebuilding.Store(true)
+
+		w := probe(internal, "/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "rebuilding file index")
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// encodingExtensions maps the encodings files can be served with to the extension of their
+// precompressed variants. Supporting a new encoding only requires adding it here.
+var encodingExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// defaultEncodingPreference is the order in which encodings are chosen among the ones
+// accepted by the client, unless configured otherwise.
+var defaultEncodingPreference = []string{"br", "zstd", "gzip"}
+
+type fileEntry struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	// ETag is derived from the content of the file, so it is the same for every
+	// replica serving it regardless of when the file was written.
+	ETag string
+	// Encodings maps the encodings the file is available in to the path of the
+	// precompressed variant.
+	Encodings map[string]string
+	// Hashed is true if the name of the file changes when its content does, so it
+	// can be cached forever.
+	Hashed bool
+	// InlineHashes are the hashes of the inline scripts and styles of HTML files, to
+	// allow them in the Content-Security-Policy header.
+	InlineHashes inlineHashes
+}
+
+type indexOptions struct {
+	// HashPattern overrides the default detection of hashed file names.
+	HashPattern *regexp.Regexp
+	// ContentTypes maps file extensions to the content types overriding the built-in ones.
+	ContentTypes map[string]string
+}
+
+type fileIndex struct {
+	Files     map[string]*fileEntry
+	Bytes     int64
+	BuildTime time.Duration
+}
+
+// buildFileIndex walks the directory once and records the files it contains, keyed by
+// their path, so requests don't need to touch the file system to find them. Files removed
+// while walking, as happens during deploys, are left out, while any other error fails the
+// whole build instead of returning a partial index. Symlinks are followed.
+func buildFileIndex(dir string, opts indexOptions) (*fileIndex, error) {
+	start := time.Now()
+	index := &fileIndex{Files: map[string]*fileEntry{}}
+	err := walkFiles(dir, func(path string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		etag, err := hashFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		file := &fileEntry{
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+			ContentType: opts.contentType(filepath.Ext(path)),
+			ETag:        etag,
+			Encodings:   map[string]string{},
+			Hashed:      isHashed(filepath.Base(path), opts.HashPattern),
+		}
+		if strings.HasPrefix(file.ContentType, "text/html") {
+			data, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			file.InlineHashes = parseInlineHashes(data)
+		}
+		index.Files[path] = file
+		index.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for path := range index.Files {
+		for encoding, extension := range encodingExtensions {
+			if !strings.HasSuffix(path, extension) {
+				continue
+			}
+			if original, ok := index.Files[strings.TrimSuffix(path, extension)]; ok {
+				original.Encodings[encoding] = path
+			}
+		}
+	}
+	for _, path := range manifestAssets(dir) {
+		if file, ok := index.Files[path]; ok {
+			file.Hashed = true
+		}
+	}
+	index.BuildTime = time.Since(start)
+
+	return index, nil
+}
+
+// walkFiles calls fn for dir and every directory and file under it, with their path under
+// dir. Unlike filepath.WalkDir, symlinks are followed, skipping the broken ones and the
+// ones to a directory that is already being walked.
+func walkFiles(dir string, fn func(path string, info fs.FileInfo) error) error {
+	return walkFilesFrom(dir, map[string]bool{}, fn)
+}
+
+func walkFilesFrom(dir string, walking map[string]bool, fn func(path string, info fs.FileInfo) error) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if walking[root] {
+		return nil
+	}
+	walking[root] = true
+	defer delete(walking, root)
+
+	return filepath.WalkDir(root, func(real string, d fs.DirEntry, err error) error {
+		// Entries removed while walking are skipped, but not a missing root.
+		if errors.Is(err, fs.ErrNotExist) && real != root {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, real)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, rel)
+		var info fs.FileInfo
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err = os.Stat(real)
+		} else {
+			info, err = d.Info()
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && d.Type()&fs.ModeSymlink != 0 {
+			return walkFilesFrom(path, walking, fn)
+		}
+
+		return fn(path, info)
+	})
+}
+
+// hashFile returns a strong ETag for the content of the file.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return formatETag(hash.Sum(nil)), nil
+}
+
+// hashContent returns a strong ETag for the content.
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return formatETag(sum[:])
+}
+
+func formatETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// diffFileIndex returns the paths of the files that are only in the current index, the ones
+// that are only in the previous one, and the ones in both that have been modified.
+func diffFileIndex(previous, current *fileIndex) (added, removed, changed []string) {
+	added, removed, changed = []string{}, []string{}, []string{}
+	for path, file := range current.Files {
+		old, ok := previous.Files[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case old.ETag != file.ETag:
+			changed = append(changed, path)
+		}
+	}
+	for path := range previous.Files {
+		if _, ok := current.Files[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	return added, removed, changed
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileIndex(t *testing.T) {
+	t.Run("indexes files with their precompressed variants", func(t *testing.T) {
+		t.Parallel()
+
+		index, err := buildFileIndex(rootDir, indexOptions{})
+		assert.NoError(t, err)
+		file, ok := index.Files[filepath.Join(rootDir, "static", "main.8d3db4ef.js")]
+
+		assert.True(t, ok)
+		assert.Equal(t, "text/javascript; charset=utf-8", file.ContentType)
+		assert.Equal(t, map[string]string{
+			"br":   filepath.Join(rootDir, "static", "main.8d3db4ef.js.br"),
+			"gzip": filepath.Join(rootDir, "static", "main.8d3db4ef.js.gz"),
+			"zstd": filepath.Join(rootDir, "static", "main.8d3db4ef.js.zst"),
+		}, file.Encodings)
+		assert.Positive(t, index.Bytes)
+		assert.Regexp(t, `^"[0-9a-f]{32}"$`, file.ETag)
+		assert.NotEqual(t, file.ETag, index.Files[file.Encodings["br"]].ETag)
+	})
+
+	t.Run("doesn't index directories", func(t *testing.T) {
+		t.Parallel()
+
+		index, err := buildFileIndex(rootDir, indexOptions{})
+		assert.NoError(t, err)
+		_, ok := index.Files[filepath.Join(rootDir, "static")]
+
+		assert.False(t, ok)
+	})
+
+	t.Run("skips files removed while indexing", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o600))
+		// A dangling symlink is listed but can't be opened, as a file removed after listing.
+		assert.NoError(t, os.Symlink(filepath.Join(dir, "removed.js"), filepath.Join(dir, "main.js")))
+
+		index, err := buildFileIndex(dir, indexOptions{})
+
+		assert.NoError(t, err)
+		assert.Len(t, index.Files, 1)
+	})
+
+	t.Run("fails instead of returning a partial index", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o600))
+		assert.NoError(t, os.Symlink("loop.js", filepath.Join(dir, "loop.js")))
+
+		_, err := buildFileIndex(dir, indexOptions{})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("follows symlinks", func(t *testing.T) {
+		t.Parallel()
+
+		target := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(target, "index.html"), []byte("<html></html>"), 0o600))
+		assets := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(assets, "main.js"), []byte("main"), 0o600))
+		assert.NoError(t, os.Symlink(assets, filepath.Join(target, "assets")))
+		// A symlink back to the root is not walked again.
+		assert.NoError(t, os.Symlink(target, filepath.Join(target, "self")))
+		dir := filepath.Join(t.TempDir(), "dist")
+		assert.NoError(t, os.Symlink(target, dir))
+
+		index, err := buildFileIndex(dir, indexOptions{})
+
+		assert.NoError(t, err)
+		assert.Len(t, index.Files, 2)
+		assert.Contains(t, index.Files, filepath.Join(dir, "index.html"))
+		assert.Contains(t, index.Files, filepath.Join(dir, "assets", "main.js"))
+	})
+
+	t.Run("diffs indexes", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		previous := &fileIndex{Files: map[string]*fileEntry{
+			"dist/index.html": {ModTime: now, ETag: `"a"`},
+			"dist/old.js":     {ModTime: now, ETag: `"b"`},
+			"dist/same.css":   {ModTime: now, ETag: `"c"`},
+		}}
+		current := &fileIndex{Files: map[string]*fileEntry{
+			"dist/index.html": {ModTime: now, ETag: `"d"`},
+			"dist/new.js":     {ModTime: now, ETag: `"b"`},
+			"dist/same.css":   {ModTime: now.Add(time.Second), ETag: `"c"`},
+		}}
+
+		added, removed, changed := diffFileIndex(previous, current)
+
+		assert.Equal(t, []string{"dist/new.js"}, added)
+		assert.Equal(t, []string{"dist/old.js"}, removed)
+		assert.Equal(t, []string{"dist/index.html"}, changed)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// locationConfig sets rules for the requests whose path matches a glob or a regular
+// expression. Globs support `*` and `?` within a path segment, `**` across segments and
+// `{a,b}` alternatives.
+type locationConfig struct {
+	Glob               string            `yaml:"glob,omitempty"`
+	Regex              string            `yaml:"regex,omitempty"`
+	CacheControl       string            `yaml:"cacheControl,omitempty"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	ContentDisposition string            `yaml:"contentDisposition,omitempty"`
+	Fallback           *bool             `yaml:"fallback,omitempty"`
+}
+
+// defaultLocations are applied after the configured ones. Well-known files that keep their
+// name between deploys must be revalidated, as well as HTML files. Anything else is cached
+// depending on whether its name is hashed.
+var defaultLocations = []locationConfig{
+	{
+		Glob:         "/{robots.txt,favicon.ico,manifest.json,*.webmanifest,sw.js,service-worker.js}",
+		CacheControl: "no-cache",
+	},
+	{
+		Glob:         "**.html",
+		CacheControl: "no-cache",
+	},
+}
+
+type location struct {
+	locationConfig
+	pattern *regexp.Regexp
+}
+
+// locationRules are the rules that apply to a request, merged from every matching location.
+type locationRules struct {
+	CacheControl       string
+	Headers            http.Header
+	ContentDisposition string
+	Fallback           *bool
+}
+
+func compileLocations(configs []locationConfig) ([]location, error) {
+	locations := make([]location, 0, len(configs))
+	for i, cfg := range configs {
+		var expr string
+		switch {
+		case cfg.Glob != "" && cfg.Regex != "":
+			return nil, fmt.Errorf("location %d: only one of glob and regex can be set", i)
+		case cfg.Glob != "":
+			expr = globToRegexp(cfg.Glob)
+		case cfg.Regex != "":
+			expr = cfg.Regex
+		default:
+			return nil, fmt.Errorf("location %d: one of glob or regex must be set", i)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("location %d: %w", i, err)
+		}
+		locations = append(locations, location{locationConfig: cfg, pattern: pattern})
+	}
+
+	return locations, nil
+}
+
+// matchLocations merges the rules of the locations matching the path. Locations are
+// evaluated in order, and for every rule the first location setting it wins.
+func matchLocations(locations []location, path string) locationRules {
+	rules := locationRules{Headers: http.Header{}}
+	for _, l := range locations {
+		if !l.pattern.MatchString(path) {
+			continue
+		}
+		if rules.CacheControl == "" {
+			rules.CacheControl = l.CacheControl
+		}
+		if rules.ContentDisposition == "" {
+			rules.ContentDisposition = l.ContentDisposition
+		}
+		if rules.Fallback == nil {
+			rules.Fallback = l.Fallback
+		}
+		for k, v := range l.Headers {
+			if rules.Headers.Get(k) == "" {
+				rules.Headers.Set(k, v)
+			}
+		}
+	}
+
+	return rules
+}
+
+func (r locationRules) apply(w http.ResponseWriter) {
+	if r.CacheControl != "" {
+		w.Header().Set("Cache-Control", r.CacheControl)
+	}
+	if r.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", r.ContentDisposition)
+	}
+	for k := range r.Headers {
+		w.Header().Set(k, r.Headers.Get(k))
+	}
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	inAlternatives := false
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '{' && !inAlternatives:
+			b.WriteString("(?:")
+			inAlternatives = true
+		case c == ',' && inAlternatives:
+			b.WriteString("|")
+		case c == '}' && inAlternatives:
+			b.WriteString(")")
+			inAlternatives = false
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocations(t *testing.T) {
+	t.Run("converts globs to regular expressions", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			glob    string
+			path    string
+			matches bool
+		}{
+			{"/static/*.js", "/static/main.js", true},
+			{"/static/*.js", "/static/nested/main.js", false},
+			{"/static/**.js", "/static/nested/main.js", true},
+			{"/file-?.txt", "/file-1.txt", true},
+			{"/{robots.txt,favicon.ico}", "/favicon.ico", true},
+			{"/{robots.txt,favicon.ico}", "/robots.txt.bak", false},
+			{"/a+b.txt", "/a+b.txt", true},
+		}
+		for _, tt := range tests {
+			locations, err := compileLocations([]locationConfig{{Glob: tt.glob}})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.matches, locations[0].pattern.MatchString(tt.path), tt.glob+" "+tt.path)
+		}
+	})
+
+	t.Run("rejects invalid locations", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := compileLocations([]locationConfig{{}})
+		assert.Error(t, err)
+
+		_, err = compileLocations([]locationConfig{{Glob: "/a", Regex: "^/a$"}})
+		assert.Error(t, err)
+
+		_, err = compileLocations([]locationConfig{{Regex: "("}})
+		assert.Error(t, err)
+	})
+
+	t.Run("merges rules with the first location taking precedence", func(t *testing.T) {
+		t.Parallel()
+
+		fallback := false
+		locations, err := compileLocations([]locationConfig{
+			{Glob: "/api/**", Fallback: &fallback, Headers: map[string]string{"X-A": "api"}},
+			{Regex: "^/api/", CacheControl: "no-store", Headers: map[string]string{"X-A": "other", "X-B": "b"}},
+			{Glob: "**", CacheControl: "public"},
+		})
+		assert.NoError(t, err)
+
+		rules := matchLocations(locations, "/api/users")
+
+		assert.Equal(t, "no-store", rules.CacheControl)
+		assert.Equal(t, &fallback, rules.Fallback)
+		assert.Equal(t, "api", rules.Headers.Get("X-A"))
+		assert.Equal(t, "b", rules.Headers.Get("X-B"))
+	})
+
+	t.Run("applies configured locations", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Locations: []locationConfig{
+			{
+				Glob:               "/static/*.LICENSE.txt",
+				CacheControl:       "public, max-age=60, stale-while-revalidate=600",
+				ContentDisposition: "attachment",
+				Headers:            map[string]string{"X-Robots-Tag": "noindex"},
+			},
+		}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js.LICENSE.txt", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "public, max-age=60, stale-while-revalidate=600", w.Header().Get("Cache-Control"))
+		assert.Equal(t, "attachment", w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "noindex", w.Header().Get("X-Robots-Tag"))
+	})
+
+	t.Run("disables the SPA fallback", func(t *testing.T) {
+		t.Parallel()
+
+		fallback := false
+		cfg := &config{Locations: []locationConfig{{Glob: "/api/**", Fallback: &fallback}}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("enables the SPA fallback for paths with extension", func(t *testing.T) {
+		t.Parallel()
+
+		fallback := true
+		cfg := &config{Locations: []locationConfig{{Glob: "/users/**", Fallback: &fallback}}}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/users/john.doe", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "html")
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"mime"
+	"strings"
+)
+
+// builtinContentTypes are the content types of the files usually found in single-page
+// apps. They are defined here rather than relying on the system MIME database, which is
+// not available in the container image.
+var builtinContentTypes = map[string]string{
+	".avif":        "image/avif",
+	".css":         "text/css",
+	".gif":         "image/gif",
+	".htm":         "text/html",
+	".html":        "text/html",
+	".ico":         "image/x-icon",
+	".jpeg":        "image/jpeg",
+	".jpg":         "image/jpeg",
+	".js":          "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".md":          "text/markdown",
+	".mjs":         "text/javascript",
+	".mp4":         "video/mp4",
+	".otf":         "font/otf",
+	".pdf":         "application/pdf",
+	".png":         "image/png",
+	".svg":         "image/svg+xml",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain",
+	".wasm":        "application/wasm",
+	".webm":        "video/webm",
+	".webmanifest": "application/manifest+json",
+	".webp":        "image/webp",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".xml":         "application/xml",
+}
+
+// textualTypes are the content types besides text/* ones that are text, so they are served
+// with an explicit charset.
+var textualTypes = map[string]bool{
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/xml":           true,
+	"image/svg+xml":             true,
+}
+
+// contentType returns the content type for the file extension, looking first at the
+// configured overrides and then at the built-in types. It returns an empty string if the
+// type is unknown.
+func (o indexOptions) contentType(ext string) string {
+	ext = strings.ToLower(ext)
+	contentType, ok := o.ContentTypes[ext]
+	if !ok {
+		contentType, ok = builtinContentTypes[ext]
+	}
+	if !ok {
+		return mime.TypeByExtension(ext)
+	}
+
+	return withCharset(contentType)
+}
+
+// withCharset adds charset=utf-8 to textual content types that don't set a charset.
+func withCharset(contentType string) string {
+	mediaType, params, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if strings.Contains(params, "charset=") {
+		return contentType
+	}
+	if !strings.HasPrefix(mediaType, "text/") && !textualTypes[mediaType] {
+		return contentType
+	}
+
+	return contentType + "; charset=utf-8"
+}
+
+// normalizeContentTypes makes the configured extensions lowercase and prefixed by a dot.
+func normalizeContentTypes(contentTypes map[string]string) map[string]string {
+	normalized := make(map[string]string, len(contentTypes))
+	for ext, contentType := range contentTypes {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized[ext] = contentType
+	}
+
+	return normalized
+}
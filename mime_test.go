@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMIMETypes(t *testing.T) {
+	t.Run("resolves built-in and overridden types", func(t *testing.T) {
+		t.Parallel()
+
+		opts := indexOptions{ContentTypes: normalizeContentTypes(map[string]string{
+			"WASM":  "application/x-custom-wasm",
+			".md":   "text/plain; charset=iso-8859-1",
+			".data": "text/csv",
+		})}
+
+		assert.Equal(t, "text/html; charset=utf-8", opts.contentType(".html"))
+		assert.Equal(t, "text/javascript; charset=utf-8", opts.contentType(".MJS"))
+		assert.Equal(t, "application/manifest+json; charset=utf-8", opts.contentType(".webmanifest"))
+		assert.Equal(t, "font/woff2", opts.contentType(".woff2"))
+		assert.Equal(t, "image/avif", opts.contentType(".avif"))
+		assert.Equal(t, "application/x-custom-wasm", opts.contentType(".wasm"))
+		assert.Equal(t, "text/plain; charset=iso-8859-1", opts.contentType(".md"))
+		assert.Equal(t, "text/csv; charset=utf-8", opts.contentType(".data"))
+		assert.Empty(t, opts.contentType(".unknown-extension"))
+	})
+
+	t.Run("serves precompressed variants of any known type", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js.LICENSE.txt", nil)
+
+		r.Header.Add("Accept-Encoding", "gzip")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+const identityEncoding = "identity"
+
+// acceptEncoding holds the quality values of the encodings listed in an Accept-Encoding
+// header, as described in RFC 9110, section 12.5.3.
+type acceptEncoding map[string]float64
+
+func parseAcceptEncoding(header string) acceptEncoding {
+	accepted := acceptEncoding{}
+	for _, element := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+
+		q, valid := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				valid = false
+				break
+			}
+			q = parsed
+		}
+		// Elements with malformed quality values are ignored rather than guessed.
+		if valid {
+			accepted[coding] = q
+		}
+	}
+
+	return accepted
+}
+
+// quality returns the quality value for the encoding, and whether it was set explicitly or
+// through a wildcard.
+func (a acceptEncoding) quality(encoding string) (float64, bool) {
+	if q, ok := a[encoding]; ok {
+		return q, true
+	}
+	if q, ok := a["*"]; ok {
+		return q, true
+	}
+
+	return 0, false
+}
+
+// negotiateEncoding picks the encoding to serve among the available ones, favoring the
+// highest quality value in the header and then the server preference. Identity is
+// acceptable unless excluded, but is only preferred if the client asks for it explicitly.
+// It returns false if no acceptable encoding is available.
+func negotiateEncoding(header string, preference []string, available map[string]bool) (string, bool) {
+	accepted := parseAcceptEncoding(header)
+
+	best, bestQ := "", 0.0
+	for _, encoding := range preference {
+		if !available[encoding] {
+			continue
+		}
+		q, _ := accepted.quality(encoding)
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	identityQ, explicit := accepted.quality(identityEncoding)
+	switch {
+	case explicit && identityQ > bestQ:
+		return identityEncoding, true
+	case best != "":
+		return best, true
+	case !explicit || identityQ > 0:
+		return identityEncoding, true
+	default:
+		return "", false
+	}
+}
+
+// availableEncodings returns the encodings the file can be served with besides identity.
+func (f *fileServer) availableEncodings(file *fileEntry) map[string]bool {
+	available := precompressedEncodings(file)
+	if file.ContentType != "" && f.canCompress(file) {
+		for encoding := range dynamicEncoders {
+			available[encoding] = true
+		}
+	}
+
+	return available
+}
+
+// precompressedEncodings returns the encodings the file has a precompressed variant for.
+func precompressedEncodings(file *fileEntry) map[string]bool {
+	available := map[string]bool{}
+	// Without a known content type, a compressed file would be served with the wrong one.
+	if file.ContentType == "" {
+		return available
+	}
+	for encoding := range file.Encodings {
+		available[encoding] = true
+	}
+
+	return available
+}
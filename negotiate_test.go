@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	available := map[string]bool{"br": true, "gzip": true}
+	preference := []string{"br", "zstd", "gzip"}
+
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+		ok       bool
+	}{
+		{"no header", "", identityEncoding, true},
+		{"single encoding", "gzip", "gzip", true},
+		{"server preference on equal quality", "gzip, br", "br", true},
+		{"highest quality", "br;q=0.5, gzip;q=0.8", "gzip", true},
+		{"excluded encoding", "br;q=0, gzip", "gzip", true},
+		{"unavailable encoding", "zstd", identityEncoding, true},
+		{"wildcard", "*", "br", true},
+		{"excluded wildcard", "gzip;q=0, *;q=0.5", "br", true},
+		{"explicit identity preferred", "identity, gzip;q=0.5", identityEncoding, true},
+		{"case insensitive", "GZIP;Q=1", "gzip", true},
+		{"x-gzip alias", "x-gzip", "gzip", true},
+		{"malformed quality", "br;q=2, gzip", "gzip", true},
+		{"identity excluded", "zstd, identity;q=0", "", false},
+		{"everything excluded", "*;q=0", "", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			encoding, ok := negotiateEncoding(tt.header, preference, available)
+
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, encoding)
+		})
+	}
+
+	t.Run("responds with 406 if no acceptable encoding is available", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		r.Header.Add("Accept-Encoding", "compress, identity;q=0")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	})
+
+	t.Run("doesn't serve encodings with a quality of zero", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		r.Header.Add("Accept-Encoding", "br;q=0, gzip")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	})
+
+	t.Run("doesn't vary responses without alternative encodings", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{}
+		fileServer := newFileServer(cfg, nil).init()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/static/main.8d3db4ef.js.LICENSE.txt.gz", nil)
+
+		r.Header.Add("Accept-Encoding", "br, gzip")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type http3Config struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// AdvertisedPort is the port announced in the Alt-Svc header, when clients connect
+	// through a different port than the one the server listens on.
+	AdvertisedPort int `yaml:"advertisedPort,omitempty"`
+}
+
+// withH2C allows clients to use HTTP/2 without TLS, as when a service mesh or load balancer
+// terminates TLS in front of the server. The HTTP/2 server is configured on the given server
+// so shutting it down also sends GOAWAY to h2c connections.
+func withH2C(server *http.Server, h http.Handler) (http.Handler, error) {
+	h2s := &http2.Server{}
+	err := http2.ConfigureServer(server, h2s)
+	if err != nil {
+		return nil, err
+	}
+	// ConfigureServer also prepares the server for TLS, which h2c doesn't use.
+	server.TLSConfig = nil
+
+	return h2c.NewHandler(h, h2s), nil
+}
+
+// http3Server serves files over QUIC, on the UDP port matching the TCP port of the file
+// server.
+type http3Server struct {
+	Server *http3.Server
+}
+
+func newHTTP3Server(cfg *config, server *http.Server, handler http.Handler) *http3Server {
+	return &http3Server{
+		Server: &http3.Server{
+			Addr:      ":" + strconv.Itoa(cfg.FilesPort),
+			Port:      cfg.HTTP3.AdvertisedPort,
+			TLSConfig: http3.ConfigureTLSConfig(server.TLSConfig),
+			Handler:   handler,
+		},
+	}
+}
+
+func (h *http3Server) run() error {
+	return h.Server.ListenAndServe()
+}
+
+// shutdown closes the QUIC listener and all connections right away, as the HTTP/3 server
+// can't drain them, so requests in flight over HTTP/3 are interrupted.
+func (h *http3Server) shutdown(ctx context.Context) error {
+	return h.Server.Close()
+}
+
+// advertise announces in every response that the server is also available over HTTP/3.
+func (h *http3Server) advertise(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Until the QUIC listener is ready, there is no port to advertise yet.
+		_ = h.Server.SetQuicHeaders(w.Header())
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestProtocols(t *testing.T) {
+	t.Run("serves HTTP/2 without TLS", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{H2C: true}
+		fileServer := newFileServer(cfg, nil).init()
+		server := httptest.NewServer(fileServer.Server.Handler)
+		defer server.Close()
+
+		client := &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+		res, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, 2, res.ProtoMajor)
+	})
+
+	t.Run("sends GOAWAY to HTTP/2 cleartext connections on shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{H2C: true}
+		fileServer := newFileServer(cfg, nil).init()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		go func() {
+			_ = fileServer.Server.Serve(listener)
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+		client, err := (&http2.Transport{AllowHTTP: true}).NewClientConn(conn)
+		assert.NoError(t, err)
+		r := httptest.NewRequest(http.MethodGet, "http://"+listener.Addr().String()+"/", nil)
+		r.RequestURI = ""
+		res, err := client.RoundTrip(r)
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, 2, res.ProtoMajor)
+
+		assert.NoError(t, fileServer.shutdown(context.Background()))
+
+		assert.Eventually(t, func() bool {
+			return !client.CanTakeNewRequest()
+		}, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("serves HTTP/3 and advertises it", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{TLS: tlsConfig{SelfSigned: true}, HTTP3: http3Config{Enabled: true}}
+		fileServer := newFileServer(cfg, nil).init()
+		defer fileServer.certs.close()
+		go func() {
+			_ = fileServer.HTTP3.run()
+		}()
+		defer fileServer.HTTP3.shutdown(context.Background())
+
+		var altSvc string
+		assert.Eventually(t, func() bool {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			fileServer.Server.Handler.ServeHTTP(w, r)
+			altSvc = w.Header().Get("Alt-Svc")
+			return altSvc != ""
+		}, 5*time.Second, 20*time.Millisecond)
+
+		port := regexp.MustCompile(`h3=":(\d+)"`).FindStringSubmatch(altSvc)
+		assert.Len(t, port, 2)
+
+		client := &http.Client{
+			Transport: &http3.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+			Timeout:   5 * time.Second,
+		}
+		res, err := client.Get("https://localhost:" + port[1] + "/")
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, 3, res.ProtoMajor)
+	})
+}
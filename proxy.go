@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type proxyConfig struct {
+	Prefix          string            `yaml:"prefix"`
+	Upstream        string            `yaml:"upstream"`
+	StripPrefix     bool              `yaml:"stripPrefix,omitempty"`
+	PreserveHost    bool              `yaml:"preserveHost,omitempty"`
+	TrustForwarded  bool              `yaml:"trustForwarded,omitempty"`
+	Timeout         time.Duration     `yaml:"timeout,omitempty"`
+	RequestHeaders  map[string]string `yaml:"requestHeaders,omitempty"  secret:"true"`
+	ResponseHeaders map[string]string `yaml:"responseHeaders,omitempty"`
+}
+
+type proxyRule struct {
+	prefix  string
+	timeout time.Duration
+	proxy   *httputil.ReverseProxy
+}
+
+// newProxyRules returns the rules sorted from the longest prefix to the shortest, so the
+// most specific one matches first.
+func newProxyRules(configs []proxyConfig) ([]proxyRule, error) {
+	rules := make([]proxyRule, 0, len(configs))
+	for i, cfg := range configs {
+		if !strings.HasPrefix(cfg.Prefix, "/") {
+			return nil, fmt.Errorf("proxy %d: prefix must start with /", i)
+		}
+		target, err := url.Parse(cfg.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %d: %w", i, err)
+		}
+		if target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+			return nil, fmt.Errorf("proxy %d: upstream must be an absolute HTTP or HTTPS URL", i)
+		}
+		rules = append(rules, proxyRule{
+			prefix:  strings.TrimSuffix(cfg.Prefix, "/"),
+			timeout: cfg.Timeout,
+			proxy:   newReverseProxy(cfg, target),
+		})
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].prefix) > len(rules[j].prefix)
+	})
+
+	return rules, nil
+}
+
+func newReverseProxy(cfg proxyConfig, target *url.URL) *httputil.ReverseProxy {
+	prefix := strings.TrimSuffix(cfg.Prefix, "/")
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if cfg.StripPrefix {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.Out.URL.Path, prefix), "/")
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(target)
+			if cfg.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+			// The forwarded headers sent by the client are dropped unless it's trusted, as
+			// when there is another proxy in front, so they can't be spoofed. The client IP is
+			// appended to the trusted X-Forwarded-For, while the host and protocol it sent
+			// replace the ones seen by gss.
+			if v, ok := pr.In.Header["X-Forwarded-For"]; ok && cfg.TrustForwarded {
+				pr.Out.Header["X-Forwarded-For"] = v
+			}
+			pr.SetXForwarded()
+			if cfg.TrustForwarded {
+				for _, header := range []string{"X-Forwarded-Host", "X-Forwarded-Proto"} {
+					if v, ok := pr.In.Header[header]; ok {
+						pr.Out.Header[header] = v
+					}
+				}
+			}
+			for k, v := range cfg.RequestHeaders {
+				if v == "" {
+					pr.Out.Header.Del(k)
+					continue
+				}
+				pr.Out.Header.Set(k, v)
+			}
+		},
+		ModifyResponse: func(res *http.Response) error {
+			for k, v := range cfg.ResponseHeaders {
+				if v == "" {
+					res.Header.Del(k)
+					continue
+				}
+				res.Header.Set(k, v)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Error().Msgf("Error proxying request to %s: %v", cfg.Upstream, err)
+			if errors.Is(err, context.DeadlineExceeded) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+func (p proxyRule) matches(path string) bool {
+	return path == p.prefix || strings.HasPrefix(path, p.prefix+"/")
+}
+
+// cleanPath returns the path cleaned like path.Clean, keeping the trailing slash.
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// proxy forwards the requests matching a proxy rule to its upstream, and passes the rest to
+// the next handler.
+func (f *fileServer) proxy(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Paths are matched and forwarded cleaned, as the rest of the handlers see them, so
+		// dot segments can't reach an upstream path protected by a different rule.
+		urlPath := cleanPath(r.URL.Path)
+		for _, rule := range f.proxies {
+			if !rule.matches(urlPath) {
+				continue
+			}
+
+			if urlPath != r.URL.Path {
+				u := *r.URL
+				u.Path = urlPath
+				u.RawPath = ""
+				r = r.WithContext(r.Context())
+				r.URL = &u
+			}
+			getRequestInfo(r).Route = routeProxy
+			// The write timeout of the server would cut slow upstream responses short, so the
+			// timeout of the rule, if any, is used instead, leaving the write timeout to write
+			// the response.
+			var deadline time.Time
+			if rule.timeout > 0 {
+				ctx, cancel := context.WithTimeout(r.Context(), rule.timeout)
+				defer cancel()
+				r = r.WithContext(ctx)
+				deadline = time.Now().Add(rule.timeout + f.Server.WriteTimeout)
+			}
+			_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+			rule.proxy.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/slow") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Upstream-Host", r.Host)
+		w.Header().Set("X-Forwarded-For-Received", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Forwarded-Proto-Received", r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("X-Forwarded-Host-Received", r.Header.Get("X-Forwarded-Host"))
+		w.Header().Set("X-Api-Key-Received", r.Header.Get("X-Api-Key"))
+		w.Header().Set("Server", "upstream")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := &config{Proxy: []proxyConfig{
+		{
+			Prefix:          "/api/",
+			Upstream:        upstream.URL,
+			StripPrefix:     true,
+			Timeout:         50 * time.Millisecond,
+			RequestHeaders:  map[string]string{"X-Api-Key": "secret"},
+			ResponseHeaders: map[string]string{"Server": ""},
+		},
+		{
+			Prefix:         "/api/v2",
+			Upstream:       upstream.URL + "/v2",
+			PreserveHost:   true,
+			TrustForwarded: true,
+		},
+	}}
+	fileServer := newFileServer(cfg, nil).init()
+
+	t.Run("proxies requests matching a prefix", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/users?page=2", nil)
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "/users", w.Header().Get("X-Path"))
+		assert.Equal(t, "192.0.2.1", w.Header().Get("X-Forwarded-For-Received"))
+		assert.Equal(t, "http", w.Header().Get("X-Forwarded-Proto-Received"))
+		assert.Equal(t, "secret", w.Header().Get("X-Api-Key-Received"))
+		assert.Empty(t, w.Header().Get("Server"))
+	})
+
+	t.Run("uses the most specific prefix", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v2/users", nil)
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		r.Header.Set("X-Forwarded-Host", "app.example.com")
+		r.Header.Set("X-Forwarded-Proto", "https")
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "/v2/api/v2/users", w.Header().Get("X-Path"))
+		assert.Equal(t, "example.com", w.Header().Get("X-Upstream-Host"))
+		assert.Equal(t, "203.0.113.7, 192.0.2.1", w.Header().Get("X-Forwarded-For-Received"))
+		assert.Equal(t, "app.example.com", w.Header().Get("X-Forwarded-Host-Received"))
+		assert.Equal(t, "https", w.Header().Get("X-Forwarded-Proto-Received"))
+	})
+
+	t.Run("times out slow upstreams", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/slow", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("isn't cut short by the write timeout", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{Proxy: []proxyConfig{
+			{Prefix: "/timeout/", Upstream: upstream.URL, Timeout: time.Second},
+			{Prefix: "/", Upstream: upstream.URL},
+		}}
+		fileServer := newFileServer(cfg, nil).init()
+		server := httptest.NewUnstartedServer(fileServer.Server.Handler)
+		// The upstream takes longer than the write timeout to respond.
+		server.Config.WriteTimeout = 100 * time.Millisecond
+		server.Start()
+		t.Cleanup(server.Close)
+
+		for _, path := range []string{"/timeout/slow", "/slow"} {
+			res, err := http.Get(server.URL + path)
+			assert.NoError(t, err)
+			if err == nil {
+				res.Body.Close()
+				assert.Equal(t, http.StatusTeapot, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("serves files for other paths", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/apis", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "html")
+	})
+
+	t.Run("matches cleaned paths", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v2/../users", nil)
+
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "/users", w.Header().Get("X-Path"))
+	})
+
+	t.Run("doesn't bypass authentication with dot segments", func(t *testing.T) {
+		t.Parallel()
+
+		htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+		// SHA-1 of "sha-password".
+		assert.NoError(t, os.WriteFile(htpasswd, []byte("bob:{SHA}MNLW6wfRtawHZ/atRhQOJCUt398=\n"), 0o600))
+		cfg := &config{
+			Proxy: []proxyConfig{{Prefix: "/api", Upstream: upstream.URL, StripPrefix: true}},
+			Auth:  authConfig{HtpasswdFile: htpasswd, Paths: []string{"/api/**"}},
+		}
+		authServer := newFileServer(cfg, nil).init()
+		t.Cleanup(func() { authServer.settings.Load().release(nil) })
+
+		for _, target := range []string{"/api/x", "/api/../api/x", "/x/../api/x", "/api/./x"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+
+			authServer.Server.Handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code, target)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/../x", nil)
+
+		authServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.NotEqual(t, http.StatusTeapot, w.Code)
+		assert.Empty(t, w.Header().Get("X-Path"))
+	})
+
+	t.Run("rejects invalid rules", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newProxyRules([]proxyConfig{{Prefix: "api", Upstream: "http://api"}})
+		assert.Error(t, err)
+
+		_, err = newProxyRules([]proxyConfig{{Prefix: "/api", Upstream: "api:3000"}})
+		assert.Error(t, err)
+	})
+}
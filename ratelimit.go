@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+type rateLimitConfig struct {
+	Tokens   uint64        `yaml:"tokens,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Burst    uint64        `yaml:"burst,omitempty"`
+	IPHeader string        `yaml:"ipHeader,omitempty"`
+}
+
+// UnmarshalYAML allows setting the rate limit as a plain number of requests per second,
+// besides the full object form.
+func (c *rateLimitConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var tokens uint64
+	if err := unmarshal(&tokens); err == nil {
+		c.Tokens = tokens
+		return nil
+	}
+
+	type plain rateLimitConfig
+	return unmarshal((*plain)(c))
+}
+
+// rateLimiter gives every client, identified by its IP, its own bucket of tokens.
+type rateLimiter struct {
+	cfg      rateLimitConfig
+	interval time.Duration
+	store    limiter.Store
+	// burstMu makes checking for and creating the bucket of a new client atomic.
+	burstMu sync.Mutex
+}
+
+func newRateLimiter(cfg rateLimitConfig) (*rateLimiter, error) {
+	interval := cfg.Interval
+	if interval == 0 {
+		interval = time.Second
+	}
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   cfg.Tokens,
+		Interval: interval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rateLimiter{cfg: cfg, interval: interval, store: store}, nil
+}
+
+// burst creates the bucket of a new client with the extra tokens available for its first
+// interval only, so the limit reported and restored on every later interval stays the same.
+func (l *rateLimiter) burst(ctx context.Context, key string) error {
+	if limit, _, _ := l.store.Get(ctx, key); limit > 0 {
+		return nil
+	}
+	l.burstMu.Lock()
+	defer l.burstMu.Unlock()
+	if limit, _, _ := l.store.Get(ctx, key); limit > 0 {
+		return nil
+	}
+	err := l.store.Set(ctx, key, l.cfg.Tokens, l.interval)
+	if err != nil {
+		return err
+	}
+
+	return l.store.Burst(ctx, key, l.cfg.Burst)
+}
+
+func (l *rateLimiter) close() error {
+	return l.store.Close(context.Background())
+}
+
+// rateLimit rejects requests from clients that have used all the tokens available for the
+// current interval.
+func (f *fileServer) rateLimit(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := f.requestSettings(r).limiter
+		if l == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := clientIP(r, l.cfg.IPHeader)
+
+		// New clients get extra tokens for their first interval so page loads that
+		// request many assets at once are not rejected.
+		if l.cfg.Burst > 0 {
+			_ = l.burst(r.Context(), key)
+		}
+
+		limit, remaining, reset, ok, err := l.store.Take(r.Context(), key)
+		// The limiter is stopped when it has been replaced by a reload while the request
+		// was using it.
+		if errors.Is(err, limiter.ErrStopped) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			log.Error().Msgf("Error checking rate limit: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resetTime := time.Unix(0, int64(reset)).UTC()
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatUint(limit, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatUint(remaining, 10))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetTime.Unix(), 10))
+
+		if !ok {
+			retryAfter := int(time.Until(resetTime).Round(time.Second).Seconds())
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			f.Metrics.IncRateLimited()
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+}
+
+// clientIP returns the IP of the client that made the request. If a header is given and
+// present in the request, the last address it contains is used, as it is the one appended by
+// the proxy in front of gss, while the previous ones are sent by the client and can be forged.
+func clientIP(r *http.Request, header string) string {
+	if header != "" {
+		if values := r.Header.Values(header); len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Run("rejects requests over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{RateLimit: &rateLimitConfig{Tokens: 1, Interval: time.Hour}}
+		fileServer := newFileServer(cfg, nil).init()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.2:1234"
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("allows a burst for new clients", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{RateLimit: &rateLimitConfig{Tokens: 1, Interval: time.Hour, Burst: 2}}
+		fileServer := newFileServer(cfg, nil).init()
+
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("only allows the burst during the first interval", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{RateLimit: &rateLimitConfig{Tokens: 1, Interval: 500 * time.Millisecond, Burst: 2}}
+		fileServer := newFileServer(cfg, nil).init()
+		t.Cleanup(func() { fileServer.settings.Load().release(nil) })
+		request := func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			fileServer.Server.Handler.ServeHTTP(w, r)
+
+			return w
+		}
+
+		for i := 0; i < 3; i++ {
+			w := request()
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, request().Code)
+
+		time.Sleep(500 * time.Millisecond)
+
+		w := request()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, http.StatusTooManyRequests, request().Code)
+	})
+
+	t.Run("keys on the configured header", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		// The first address is forged by the client, and the last one appended by the proxy.
+		r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+
+		assert.Equal(t, "203.0.113.7", clientIP(r, "X-Forwarded-For"))
+		assert.Equal(t, "192.0.2.1", clientIP(r, ""))
+
+		r.Header.Add("X-Forwarded-For", "203.0.113.8")
+		assert.Equal(t, "203.0.113.8", clientIP(r, "X-Forwarded-For"))
+	})
+
+	t.Run("parses the short and full config forms", func(t *testing.T) {
+		t.Parallel()
+
+		var short config
+		err := yaml.Unmarshal([]byte("rateLimit: 10"), &short)
+
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(10), short.RateLimit.Tokens)
+
+		var full config
+		err = yaml.Unmarshal([]byte("rateLimit:\n  tokens: 5\n  interval: 1m\n  burst: 20\n"), &full)
+
+		assert.NoError(t, err)
+		assert.Equal(t, rateLimitConfig{Tokens: 5, Interval: time.Minute, Burst: 20}, *full.RateLimit)
+	})
+}
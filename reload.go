@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// reloadableOptions are the options applied by reloading the config. Changing any other
+// option requires a restart.
+var reloadableOptions = []string{
+	"headers",
+	"headerPresets",
+	"encodings",
+	"locations",
+	"assets.unhashedMaxAge",
+	"csp",
+	"rateLimit",
+	"auth",
+}
+
+// configReloadDebounce is how long the config file has to stay unchanged before reloading
+// it, as editors often write a file in several steps.
+const configReloadDebounce = 100 * time.Millisecond
+
+// handlerSettings holds the options used by the handlers of the file server, which are
+// replaced as a whole when the config is reloaded.
+type handlerSettings struct {
+	config    *config
+	headers   http.Header
+	encodings []string
+	locations []location
+	csp       *contentSecurityPolicy
+	limiter   *rateLimiter
+	auth      *authenticator
+}
+
+// newHandlerSettings builds the settings of the config. The rate limiter and the
+// authenticator of the previous settings, if any, are kept when their options have not
+// changed, so clients keep their remaining tokens and verified credentials.
+func newHandlerSettings(cfg *config, previous *handlerSettings) (*handlerSettings, error) {
+	s := &handlerSettings{
+		config:    cfg,
+		headers:   cfg.responseHeaders(),
+		encodings: cfg.Encodings,
+	}
+	if len(s.encodings) == 0 {
+		s.encodings = defaultEncodingPreference
+	}
+	locations, err := compileLocations(append(append([]locationConfig{}, cfg.Locations...), defaultLocations...))
+	if err != nil {
+		return nil, fmt.Errorf("compiling locations: %w", err)
+	}
+	s.locations = locations
+	if cfg.CSP.enabled() {
+		s.csp = newContentSecurityPolicy(cfg.CSP)
+	}
+
+	if cfg.RateLimit != nil {
+		if previous != nil && previous.limiter != nil && previous.limiter.cfg == *cfg.RateLimit {
+			s.limiter = previous.limiter
+		} else {
+			s.limiter, err = newRateLimiter(*cfg.RateLimit)
+			if err != nil {
+				return nil, fmt.Errorf("configuring rate limit: %w", err)
+			}
+		}
+	}
+	if cfg.Auth.enabled() {
+		if previous != nil && previous.auth != nil && reflect.DeepEqual(previous.auth.cfg, cfg.Auth) {
+			s.auth = previous.auth
+		} else {
+			s.auth, err = newAuthenticator(cfg.Auth)
+			if err != nil {
+				s.release(previous)
+				return nil, fmt.Errorf("loading htpasswd file: %w", err)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// release closes the parts of the settings that are not kept by the next ones, which can be
+// nil to close all of them.
+func (s *handlerSettings) release(next *handlerSettings) {
+	if s.limiter != nil && (next == nil || next.limiter != s.limiter) {
+		err := s.limiter.close()
+		if err != nil {
+			log.Error().Msgf("Error closing rate limiter: %v", err)
+		}
+	}
+	if s.auth != nil && (next == nil || next.auth != s.auth) {
+		err := s.auth.close()
+		if err != nil {
+			log.Error().Msgf("Error closing htpasswd watcher: %v", err)
+		}
+	}
+}
+
+// requestSettings returns the handler settings of the request. They are loaded once per
+// tracked request, so a reload never applies halfway through it.
+func (f *fileServer) requestSettings(r *http.Request) *handlerSettings {
+	info := getRequestInfo(r)
+	if info.Settings == nil {
+		info.Settings = f.settings.Load()
+	}
+
+	return info.Settings
+}
+
+// applyConfig replaces the handler settings with the ones of the config, and warns about the
+// changed options that are not applied until a restart.
+func (f *fileServer) applyConfig(cfg *config) error {
+	previous := f.settings.Load()
+	settings, err := newHandlerSettings(cfg, previous)
+	if err != nil {
+		return err
+	}
+	f.settings.Store(settings)
+	previous.release(settings)
+
+	for _, path := range restartRequired(f.Config, cfg) {
+		log.Warn().Msgf("Option %s changed, restart to apply it", path)
+	}
+
+	return nil
+}
+
+// restartRequired returns the options that differ between the configs and can't be
+// reloaded.
+func restartRequired(current, next *config) []string {
+	var paths []string
+	for _, field := range configFields() {
+		if reloadable(field.Path) {
+			continue
+		}
+		a, b := field.value(current), field.value(next)
+		if a.IsValid() != b.IsValid() || a.IsValid() && !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			paths = append(paths, field.Path)
+		}
+	}
+
+	return paths
+}
+
+func reloadable(path string) bool {
+	for _, option := range reloadableOptions {
+		if path == option || strings.HasPrefix(path, option+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configReloader reloads the config when the process receives SIGHUP or the config file
+// changes. If the new config is invalid, the current one is kept.
+type configReloader struct {
+	args       []string
+	file       string
+	fileServer *fileServer
+	signals    chan os.Signal
+	watcher    *fsnotify.Watcher
+	done       chan struct{}
+}
+
+func newConfigReloader(args []string, fileServer *fileServer) (*configReloader, error) {
+	file := fileServer.Config.file
+	// The directory is watched instead of the file for the same reasons as certificates,
+	// which also allows the file to be created after starting.
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	err = watcher.Add(filepath.Dir(file))
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	r := &configReloader{
+		args:       args,
+		file:       file,
+		fileServer: fileServer,
+		signals:    make(chan os.Signal, 1),
+		watcher:    watcher,
+		done:       make(chan struct{}),
+	}
+	signal.Notify(r.signals, syscall.SIGHUP)
+
+	return r, nil
+}
+
+func (r *configReloader) run() error {
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-r.done:
+			return nil
+		case <-r.signals:
+			log.Info().Msg("Received SIGHUP, reloading config")
+			r.reload()
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) || filepath.Clean(event.Name) != filepath.Clean(r.file) {
+				continue
+			}
+			debounce = time.After(configReloadDebounce)
+		case <-debounce:
+			debounce = nil
+			log.Info().Msg("Config file changed, reloading config")
+			r.reload()
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error().Msgf("Error watching config file: %v", err)
+		}
+	}
+}
+
+func (r *configReloader) reload() {
+	cfg, err := readConfig(r.args)
+	if err == nil {
+		err = r.fileServer.applyConfig(cfg)
+	}
+	if err != nil {
+		log.Error().Msgf("Error reloading config, keeping the current one: %v", err)
+		r.fileServer.Metrics.IncConfigReloadErrors()
+		return
+	}
+	log.Info().Msg("Config reloaded")
+}
+
+func (r *configReloader) shutdown(ctx context.Context) error {
+	signal.Stop(r.signals)
+	close(r.done)
+
+	return r.watcher.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	request := func(fileServer *fileServer) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		fileServer.Server.Handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("applies handler settings", func(t *testing.T) {
+		t.Parallel()
+
+		fileServer := newFileServer(&config{Headers: map[string]string{"X-Test": "before"}}, nil).init()
+		assert.Equal(t, "before", request(fileServer).Header().Get("X-Test"))
+
+		cfg := &config{
+			Headers:   map[string]string{"X-Test": "after"},
+			RateLimit: &rateLimitConfig{Tokens: 1, Interval: time.Hour},
+		}
+		err := fileServer.applyConfig(cfg)
+		assert.NoError(t, err)
+		t.Cleanup(func() { fileServer.settings.Load().release(nil) })
+
+		assert.Equal(t, "after", request(fileServer).Header().Get("X-Test"))
+		assert.Equal(t, http.StatusTooManyRequests, request(fileServer).Code)
+	})
+
+	t.Run("keeps the rate limiter if its options don't change", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &config{RateLimit: &rateLimitConfig{Tokens: 1, Interval: time.Hour}}
+		fileServer := newFileServer(cfg, nil).init()
+		t.Cleanup(func() { fileServer.settings.Load().release(nil) })
+		assert.Equal(t, http.StatusOK, request(fileServer).Code)
+
+		err := fileServer.applyConfig(&config{
+			Headers:   map[string]string{"X-Test": "after"},
+			RateLimit: &rateLimitConfig{Tokens: 1, Interval: time.Hour},
+		})
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusTooManyRequests, request(fileServer).Code)
+	})
+
+	t.Run("reloads when the file changes and keeps the config if invalid", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "gss.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte("headers:\n  X-Test: before\n"), 0o600))
+		args := []string{"--config", file}
+		fileServer := newFileServer(loadConfig(args), nil).init()
+		reloader, err := newConfigReloader(args, fileServer)
+		assert.NoError(t, err)
+		go reloader.run()
+		t.Cleanup(func() { reloader.shutdown(context.Background()) })
+
+		assert.NoError(t, os.WriteFile(file, []byte("headers:\n  X-Test: after\n"), 0o600))
+		assert.Eventually(t, func() bool {
+			return request(fileServer).Header().Get("X-Test") == "after"
+		}, 5*time.Second, 20*time.Millisecond)
+
+		assert.NoError(t, os.WriteFile(file, []byte("filesPort: 70000\nheaders:\n  X-Test: invalid\n"), 0o600))
+		reloader.reload()
+		assert.Equal(t, "after", request(fileServer).Header().Get("X-Test"))
+	})
+
+	t.Run("reports options that require a restart", func(t *testing.T) {
+		t.Parallel()
+
+		current := newConfig()
+		next := newConfig()
+		next.FilesPort = 9090
+		next.Headers = map[string]string{"X-Test": "after"}
+		next.RateLimit = &rateLimitConfig{Tokens: 10}
+		next.Compression.Enabled = true
+
+		assert.Equal(t, []string{"filesPort", "compression.enabled"}, restartRequired(current, next))
+	})
+}
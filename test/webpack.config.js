@@ -56,5 +56,12 @@ module.exports = {
       test: /\.(html|css|js|svg)$/,
       threshold: 0,
     }),
+    new CompressionPlugin({
+      algorithm: "zstdCompress",
+      filename: "[name][ext].zst",
+      minRatio: Number.MAX_SAFE_INTEGER,
+      test: /\.(html|css|js|svg)$/,
+      threshold: 0,
+    }),
   ],
 };
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+type tlsConfig struct {
+	CertFile     string              `yaml:"certFile,omitempty"`
+	KeyFile      string              `yaml:"keyFile,omitempty"`
+	Certificates []certificateConfig `yaml:"certificates,omitempty"`
+	MinVersion   string              `yaml:"minVersion,omitempty"`
+	CipherSuites []string            `yaml:"cipherSuites,omitempty"`
+	SelfSigned   bool                `yaml:"selfSigned,omitempty"`
+}
+
+type certificateConfig struct {
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+}
+
+func (c *tlsConfig) enabled() bool {
+	return c.CertFile != "" || len(c.Certificates) > 0 || c.SelfSigned
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig returns the TLS configuration for the server, along with the store of the
+// certificates it serves, which must be closed once the server is done.
+func newTLSConfig(cfg *tlsConfig) (*tls.Config, *certificateStore, error) {
+	minVersion := tls.VersionTLS12
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown TLS version: %s", cfg.MinVersion)
+		}
+		minVersion = int(version)
+	}
+
+	var cipherSuites []uint16
+	for _, name := range cfg.CipherSuites {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown or insecure cipher suite: %s", name)
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+
+	pairs := cfg.Certificates
+	if cfg.CertFile != "" {
+		pairs = append([]certificateConfig{{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile}}, pairs...)
+	}
+	store, err := newCertificateStore(pairs, cfg.SelfSigned)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     uint16(minVersion),
+		CipherSuites:   cipherSuites,
+		GetCertificate: store.getCertificate,
+	}, store, nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+
+	return 0, false
+}
+
+// certificateStore holds the certificates served, reloading them when their files change so
+// rotated certificates are picked up without a restart.
+type certificateStore struct {
+	mu      sync.RWMutex
+	pairs   []certificateConfig
+	certs   []*tls.Certificate
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func newCertificateStore(pairs []certificateConfig, selfSigned bool) (*certificateStore, error) {
+	s := &certificateStore{pairs: pairs}
+	if len(pairs) == 0 {
+		if !selfSigned {
+			return nil, fmt.Errorf("no certificates configured")
+		}
+		log.Warn().Msg("Serving an ephemeral self-signed certificate, which should only be used for development")
+		cert, err := selfSignedCertificate("localhost")
+		if err != nil {
+			return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+		s.certs = []*tls.Certificate{cert}
+		return s, nil
+	}
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Directories are watched instead of files, as mounted secrets are updated by replacing
+	// a symlink and editors often replace files instead of writing to them.
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]bool{}
+	for _, pair := range pairs {
+		dirs[filepath.Dir(pair.CertFile)] = true
+		dirs[filepath.Dir(pair.KeyFile)] = true
+	}
+	for dir := range dirs {
+		err = watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("watching certificates: %w", err)
+		}
+	}
+	s.watcher = watcher
+	s.done = make(chan struct{})
+	go s.watch()
+
+	return s, nil
+}
+
+func (s *certificateStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(s.pairs))
+	for _, pair := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate %s: %w", pair.CertFile, err)
+		}
+		// The parsed leaf is needed to select certificates by SNI.
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parsing certificate %s: %w", pair.CertFile, err)
+		}
+		certs = append(certs, &cert)
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *certificateStore) watch() {
+	defer close(s.done)
+
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			// If the new files are invalid, or only one of them has been written yet, the
+			// previous certificates keep being served.
+			err := s.load()
+			if err != nil {
+				log.Debug().Msgf("Certificates not reloaded: %v", err)
+				continue
+			}
+			log.Info().Msg("Certificates reloaded")
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Msgf("Error watching certificates: %v", err)
+		}
+	}
+}
+
+// getCertificate returns the first certificate valid for the server name requested by the
+// client, or the first one if none is.
+func (s *certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, cert := range s.certs {
+		if cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+			return cert, nil
+		}
+	}
+
+	return s.certs[0], nil
+}
+
+func (s *certificateStore) close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	err := s.watcher.Close()
+	<-s.done
+
+	return err
+}
+
+func selfSignedCertificate(dnsName string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"GSS"}, CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
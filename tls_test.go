@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes a self-signed certificate for the name and its key to the directory.
+func writeCertificate(t *testing.T, dir, name string) certificateConfig {
+	t.Helper()
+
+	cert, err := selfSignedCertificate(name)
+	assert.NoError(t, err)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assert.NoError(t, err)
+
+	pair := certificateConfig{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	assert.NoError(t, os.WriteFile(pair.KeyFile, keyPEM, 0o600))
+	assert.NoError(t, os.WriteFile(pair.CertFile, certPEM, 0o600))
+
+	return pair
+}
+
+func TestTLS(t *testing.T) {
+	t.Run("selects certificates by server name", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		first := writeCertificate(t, dir, "first.example.com")
+		second := writeCertificate(t, dir, "second.example.com")
+
+		tlsConfig, store, err := newTLSConfig(&tlsConfig{
+			CertFile:     first.CertFile,
+			KeyFile:      first.KeyFile,
+			Certificates: []certificateConfig{second},
+		})
+		assert.NoError(t, err)
+		defer store.close()
+
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "second.example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "second.example.com", cert.Leaf.Subject.CommonName)
+
+		cert, err = tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "first.example.com", cert.Leaf.Subject.CommonName)
+	})
+
+	t.Run("reloads certificates when they change", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		pair := writeCertificate(t, dir, "example.com")
+		store, err := newCertificateStore([]certificateConfig{pair}, false)
+		assert.NoError(t, err)
+		defer store.close()
+
+		cert, err := store.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		assert.NoError(t, err)
+		serial := cert.Leaf.SerialNumber
+
+		writeCertificate(t, dir, "example.com")
+
+		assert.Eventually(t, func() bool {
+			cert, err := store.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+			return err == nil && cert.Leaf.SerialNumber.Cmp(serial) != 0
+		}, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("generates a self-signed certificate", func(t *testing.T) {
+		t.Parallel()
+
+		tlsConfig, store, err := newTLSConfig(&tlsConfig{SelfSigned: true, MinVersion: "1.3"})
+		assert.NoError(t, err)
+		defer store.close()
+
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"localhost"}, cert.Leaf.DNSNames)
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := newTLSConfig(&tlsConfig{SelfSigned: true, MinVersion: "2.0"})
+		assert.Error(t, err)
+
+		_, _, err = newTLSConfig(&tlsConfig{SelfSigned: true, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}})
+		assert.Error(t, err)
+
+		_, _, err = newTLSConfig(&tlsConfig{CertFile: "missing.crt", KeyFile: "missing.key"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// fileWatcher rebuilds the file index when the files in the directory change. Changes are
+// debounced, so a deploy writing many files results in a single rebuild once it's done.
+type fileWatcher struct {
+	dir      string
+	opts     indexOptions
+	debounce time.Duration
+	index    *atomic.Pointer[fileIndex]
+	metrics  *metrics
+	watcher  *fsnotify.Watcher
+	done     chan struct{}
+	// rebuilding is true while the index is being rebuilt.
+	rebuilding atomic.Bool
+}
+
+func newFileWatcher(
+	dir string,
+	opts indexOptions,
+	debounce time.Duration,
+	index *atomic.Pointer[fileIndex],
+	metrics *metrics,
+) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fileWatcher{
+		dir:      dir,
+		opts:     opts,
+		debounce: debounce,
+		index:    index,
+		metrics:  metrics,
+		watcher:  watcher,
+		done:     make(chan struct{}),
+	}
+	err = w.addDirs()
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go w.run()
+
+	return w, nil
+}
+
+func (w *fileWatcher) run() {
+	defer close(w.done)
+
+	// The timer is only started once the first change is received.
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			timer.Reset(w.debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			log.Error().Msgf("Error watching files: %v", err)
+		case <-timer.C:
+			w.refresh()
+		}
+	}
+}
+
+// refresh rebuilds the index and swaps it with the current one.
+func (w *fileWatcher) refresh() {
+	w.rebuilding.Store(true)
+	defer w.rebuilding.Store(false)
+
+	// New directories need to be watched too, as watches are not recursive.
+	err := w.addDirs()
+	if err != nil {
+		log.Error().Msgf("Error watching files: %v", err)
+	}
+
+	// If the index can't be rebuilt, the previous one keeps being served rather than a
+	// partial one.
+	index, err := buildFileIndex(w.dir, w.opts)
+	if err != nil {
+		log.Error().Msgf("Error refreshing file index, keeping the previous one: %v", err)
+		return
+	}
+	previous := w.index.Swap(index)
+	w.metrics.SetFileIndex(index)
+
+	added, removed, changed := diffFileIndex(previous, index)
+	log.Info().
+		Int("files", len(index.Files)).
+		Dur("buildTime", index.BuildTime).
+		Strs("added", added).
+		Strs("removed", removed).
+		Strs("changed", changed).
+		Msg("File index refreshed")
+}
+
+func (w *fileWatcher) addDirs() error {
+	return walkFiles(w.dir, func(path string, info fs.FileInfo) error {
+		if !info.IsDir() {
+			return nil
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+func (w *fileWatcher) close() error {
+	err := w.watcher.Close()
+	<-w.done
+
+	return err
+}
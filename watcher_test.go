@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWatcher(t *testing.T) {
+	t.Run("refreshes the index when files change", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o600))
+
+		var index atomic.Pointer[fileIndex]
+		initial, err := buildFileIndex(dir, indexOptions{})
+		assert.NoError(t, err)
+		index.Store(initial)
+		watcher, err := newFileWatcher(dir, indexOptions{}, 50*time.Millisecond, &index, nil)
+		assert.NoError(t, err)
+		defer watcher.close()
+
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, "static"), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "static", "main.js"), []byte("main()"), 0o600))
+
+		assert.Eventually(t, func() bool {
+			_, ok := index.Load().Files[filepath.Join(dir, "static", "main.js")]
+			return ok
+		}, 5*time.Second, 20*time.Millisecond)
+
+		assert.NoError(t, os.Remove(filepath.Join(dir, "index.html")))
+
+		assert.Eventually(t, func() bool {
+			_, ok := index.Load().Files[filepath.Join(dir, "index.html")]
+			return !ok
+		}, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("keeps the previous index if the rebuild fails", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o600))
+
+		var index atomic.Pointer[fileIndex]
+		initial, err := buildFileIndex(dir, indexOptions{})
+		assert.NoError(t, err)
+		index.Store(initial)
+		watcher, err := newFileWatcher(dir, indexOptions{}, time.Hour, &index, nil)
+		assert.NoError(t, err)
+		defer watcher.close()
+
+		assert.NoError(t, os.Symlink("loop.js", filepath.Join(dir, "loop.js")))
+		watcher.refresh()
+
+		assert.Same(t, initial, index.Load())
+		assert.False(t, watcher.rebuilding.Load())
+	})
+}